@@ -0,0 +1,624 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+	"sigs.k8s.io/yaml"
+)
+
+// Config is the content of the configuration file, in yaml or json format
+type Config struct {
+	// the filters, drain, interval and policy settings apply to all clusters, on top of the command line flags
+	FilterConfig   `json:",inline"`
+	DrainConfig    `json:",inline"`
+	Interval       *int `json:"interval,omitempty"`
+	PolicySettings `json:",inline"`
+
+	// Clusters lists the clusters to kill preemptible nodes in, when empty the cluster of the command line flags is used
+	Clusters []ClusterConfig `json:"clusters,omitempty"`
+
+	// Policies lists the node pool policies of all clusters, after the policies of the cluster itself
+	Policies []PolicyConfig `json:"policies,omitempty"`
+}
+
+// ClusterConfig configures a cluster to kill preemptible nodes in, unset settings fall back to the top level settings
+// of the config file and then to the command line flags
+type ClusterConfig struct {
+	Name           string `json:"name"`
+	Kubeconfig     string `json:"kubeconfig,omitempty"`
+	Context        string `json:"context,omitempty"`
+	FilterConfig   `json:",inline"`
+	DrainConfig    `json:",inline"`
+	Interval       *int `json:"interval,omitempty"`
+	PolicySettings `json:",inline"`
+
+	// Policies lists the node pool policies of the cluster, the first one matching a node applies
+	Policies []PolicyConfig `json:"policies,omitempty"`
+}
+
+// PolicyConfig configures the policy of the nodes of a node pool or matching a label selector, unset settings fall
+// back to the policy of the cluster
+type PolicyConfig struct {
+	Name           string `json:"name"`
+	NodePool       string `json:"nodePool,omitempty"`
+	Selector       string `json:"selector,omitempty"`
+	PolicySettings `json:",inline"`
+}
+
+// PolicySettings are the settings a cluster or node pool policy can override
+type PolicySettings struct {
+	Enabled         *bool            `json:"enabled,omitempty"`
+	WhitelistHours  *string          `json:"whitelistHours,omitempty"`
+	BlacklistHours  *string          `json:"blacklistHours,omitempty"`
+	DrainTimeout    *int             `json:"drainTimeout,omitempty"`
+	KillBudget      *int             `json:"killBudget,omitempty"`
+	ExpiryPlanning  *string          `json:"expiryPlanning,omitempty"`
+	MinimumLifetime *metav1.Duration `json:"minimumLifetime,omitempty"`
+	MaximumLifetime *metav1.Duration `json:"maximumLifetime,omitempty"`
+
+	MinimumReadyNodes    *int             `json:"minimumReadyNodes,omitempty"`
+	ScaleDownGracePeriod *metav1.Duration `json:"scaleDownGracePeriod,omitempty"`
+	KillUnhealthy        *bool            `json:"killUnhealthy,omitempty"`
+	UnhealthyGracePeriod *metav1.Duration `json:"unhealthyGracePeriod,omitempty"`
+	MaximumGracePeriod   *metav1.Duration `json:"maximumGracePeriod,omitempty"`
+	DrainTimeoutAction   *string          `json:"drainTimeoutAction,omitempty"`
+	DeleteGracePeriod    *metav1.Duration `json:"deleteGracePeriod,omitempty"`
+	DeleteIgnorePDBs     *bool            `json:"deleteIgnorePDBs,omitempty"`
+	LocalStoragePods     *string          `json:"localStoragePods,omitempty"`
+	BarePods             *string          `json:"barePods,omitempty"`
+}
+
+// Policy holds the settings that determine when and how nodes get killed
+type Policy struct {
+	// Name identifies the policy in logs
+	Name string
+
+	// Source tells where the policy comes from, so policies with the same name from different sources don't share
+	// their status and kill budget
+	Source string
+
+	// Selector matches the nodes the policy applies to, nil for the default policy
+	Selector labels.Selector
+
+	// Enabled is false when the nodes of the policy should be left alone
+	Enabled bool
+
+	// Whitelist holds the whitelist and blacklist hours in which nodes can be killed
+	Whitelist WhitelistInstance
+
+	// DrainTimeout is the max time in second to wait for a node to drain, extended to the longest termination grace
+	// period of its pods up to the MaximumGracePeriod
+	DrainTimeout int
+
+	// MaximumGracePeriod is the longest termination grace period pods get when they're evicted
+	MaximumGracePeriod time.Duration
+
+	// DrainTimeoutAction is what happens to the pods left on a node when its drain times out: proceed, abort or
+	// delete them with the DeleteGracePeriod, unless their disruption budget allows no disruption and DeleteIgnorePDBs
+	// is false
+	DrainTimeoutAction string
+	DeleteGracePeriod  time.Duration
+	DeleteIgnorePDBs   bool
+
+	// LocalStoragePods and BarePods are what happens when pods with emptyDir volumes or without a controller are on a
+	// node to kill: proceed, postpone or refuse to kill the node
+	LocalStoragePods string
+	BarePods         string
+
+	// KillBudget is the max number of nodes of the policy to kill per interval, 0 for no limit
+	KillBudget int
+
+	// ExpiryPlanning is the strategy to pick the expiry of a new node, random or spread
+	ExpiryPlanning string
+
+	// MinimumLifetime is the time after its creation before which a node is not killed
+	MinimumLifetime time.Duration
+
+	// MaximumLifetime is the time after its creation by which a node has to be killed
+	MaximumLifetime time.Duration
+
+	// MinimumReadyNodes is the number of ready nodes a node pool keeps, kills that would leave less are postponed
+	MinimumReadyNodes int
+
+	// ScaleDownGracePeriod is the time after its creation during which the cluster autoscaler can't remove a node
+	ScaleDownGracePeriod time.Duration
+
+	// KillUnhealthy kills nodes right away once they've been unhealthy for the UnhealthyGracePeriod
+	KillUnhealthy        bool
+	UnhealthyGracePeriod time.Duration
+}
+
+// clusterSettings are the settings of a cluster resolved from the command line flags and the config file, which can be
+// reloaded while running
+type clusterSettings struct {
+	filters  NodeFilter
+	drain    DrainOptions
+	interval int
+	policy   Policy
+	policies []Policy
+}
+
+// readConfigFile reads the configuration file from a given path
+func readConfigFile(path string) (config Config, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		err = fmt.Errorf("Error reading config file %v:\n%v", path, err)
+		return
+	}
+
+	return parseConfig(path, data)
+}
+
+// parseConfig parses and checks the content of the configuration file at the given path
+func parseConfig(path string, data []byte) (config Config, err error) {
+	err = yaml.UnmarshalStrict(data, &config)
+	if err != nil {
+		err = fmt.Errorf("Error parsing config file %v:\n%v", path, err)
+		return
+	}
+
+	err = validateSettings(config.FilterConfig, config.DrainConfig, config.Interval, config.PolicySettings)
+	if err != nil {
+		err = fmt.Errorf("Error in config file %v: %v", path, err)
+		return
+	}
+
+	for i, cluster := range config.Clusters {
+		if cluster.Name == "" {
+			err = fmt.Errorf("Error in config file %v: cluster %d has no name", path, i)
+			return
+		}
+		err = validateSettings(cluster.FilterConfig, cluster.DrainConfig, cluster.Interval, cluster.PolicySettings)
+		if err != nil {
+			err = fmt.Errorf("Error in config file %v, cluster %v: %v", path, cluster.Name, err)
+			return
+		}
+		err = validatePolicies(cluster.Policies)
+		if err != nil {
+			err = fmt.Errorf("Error in config file %v, cluster %v: %v", path, cluster.Name, err)
+			return
+		}
+	}
+
+	err = validatePolicies(config.Policies)
+	if err != nil {
+		err = fmt.Errorf("Error in config file %v: %v", path, err)
+		return
+	}
+
+	return
+}
+
+// validatePolicies checks the node pool policies can be applied
+func validatePolicies(policies []PolicyConfig) error {
+	for i, policy := range policies {
+		if policy.Name == "" {
+			return fmt.Errorf("policy %d has no name", i)
+		}
+		if policy.NodePool == "" && policy.Selector == "" {
+			return fmt.Errorf("policy %v has neither a node pool nor a selector", policy.Name)
+		}
+		if _, err := policy.getSelector(); err != nil {
+			return fmt.Errorf("policy %v has an invalid selector: %v", policy.Name, err)
+		}
+		if err := policy.PolicySettings.validate(); err != nil {
+			return fmt.Errorf("policy %v: %v", policy.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// validateSettings checks the settings of the config file, at the top level or of a cluster, can be applied
+func validateSettings(filter FilterConfig, drain DrainConfig, interval *int, policy PolicySettings) error {
+	if _, err := filter.getNodeFilter(); err != nil {
+		return err
+	}
+	if _, err := drain.getDrainOptions(); err != nil {
+		return err
+	}
+	if interval != nil && *interval < 1 {
+		return fmt.Errorf("interval %d should be at least 1 second", *interval)
+	}
+
+	return policy.validate()
+}
+
+// getClusters returns the clusters of the config file, or the cluster of the command line flags when it lists none
+func (c Config) getClusters() []ClusterConfig {
+	if len(c.Clusters) > 0 {
+		return c.Clusters
+	}
+	return []ClusterConfig{{Kubeconfig: *kubeConfigPath, Context: *kubeContext}}
+}
+
+// getCluster returns the cluster with the given name, the cluster of the command line flags has no name
+func (c Config) getCluster(name string) (cluster ClusterConfig, ok bool) {
+	for _, cluster = range c.getClusters() {
+		if cluster.Name == name {
+			return cluster, true
+		}
+	}
+
+	return ClusterConfig{}, false
+}
+
+// getClusterSettings resolves the settings of a cluster, applying the top level settings of the config file and those
+// of the cluster on top of the given default policy
+func (c Config) getClusterSettings(cluster ClusterConfig, defaultPolicy Policy) (settings clusterSettings, err error) {
+	settings.filters, err = cluster.FilterConfig.apply(c.FilterConfig.apply(newDefaultFilterConfig())).getNodeFilter()
+	if err != nil {
+		return
+	}
+
+	settings.drain, err = cluster.DrainConfig.apply(c.DrainConfig.apply(newDefaultDrainConfig())).getDrainOptions()
+	if err != nil {
+		return
+	}
+
+	defaultInterval := *interval
+	if c.Interval != nil {
+		defaultInterval = *c.Interval
+	}
+	settings.interval = cluster.getInterval(defaultInterval)
+
+	configPolicy, err := c.PolicySettings.apply(defaultPolicy)
+	if err != nil {
+		return
+	}
+	settings.policy, err = cluster.getPolicy(configPolicy)
+	if err != nil {
+		return
+	}
+	settings.policies, err = cluster.getNodePoolPolicies(settings.policy, c.Policies)
+	if err != nil {
+		return
+	}
+
+	return
+}
+
+// diff describes the changes from the given previous settings, one line per changed setting
+func (s clusterSettings) diff(previous clusterSettings) (changes []string) {
+	if s.filters.String() != previous.filters.String() {
+		changes = append(changes, fmt.Sprintf("filters: %v -> %v", previous.filters, s.filters))
+	}
+	if s.drain.String() != previous.drain.String() {
+		changes = append(changes, fmt.Sprintf("drain: %v -> %v", previous.drain, s.drain))
+	}
+	if s.interval != previous.interval {
+		changes = append(changes, fmt.Sprintf("interval: %v -> %v", previous.interval, s.interval))
+	}
+
+	changes = append(changes, diffPolicy(previous.policy, s.policy)...)
+
+	previousPolicies := map[string]Policy{}
+	for _, policy := range previous.policies {
+		previousPolicies[policy.Name] = policy
+	}
+	for _, policy := range s.policies {
+		previousPolicy, ok := previousPolicies[policy.Name]
+		if !ok {
+			changes = append(changes, fmt.Sprintf("policy %v: added", policy.Name))
+			continue
+		}
+		changes = append(changes, diffPolicy(previousPolicy, policy)...)
+		delete(previousPolicies, policy.Name)
+	}
+	for _, policy := range previous.policies {
+		if _, ok := previousPolicies[policy.Name]; ok {
+			changes = append(changes, fmt.Sprintf("policy %v: removed", policy.Name))
+		}
+	}
+
+	return
+}
+
+// diffPolicy describes the changed settings of a policy
+func diffPolicy(previous, policy Policy) (changes []string) {
+	previousSettings := previous.describe()
+	settings := policy.describe()
+	for _, setting := range policySettingNames {
+		if previousSettings[setting] != settings[setting] {
+			changes = append(changes, fmt.Sprintf("policy %v: %v: %v -> %v", policy.Name, setting, previousSettings[setting], settings[setting]))
+		}
+	}
+
+	return
+}
+
+// policySettingNames lists the settings of a policy in the order they are described
+var policySettingNames = []string{"selector", "enabled", "whitelistHours", "blacklistHours", "drainTimeout", "killBudget", "expiryPlanning", "minimumLifetime", "maximumLifetime", "minimumReadyNodes", "scaleDownGracePeriod", "killUnhealthy", "unhealthyGracePeriod", "maximumGracePeriod", "drainTimeoutAction", "deleteGracePeriod", "deleteIgnorePDBs", "localStoragePods", "barePods"}
+
+// describe returns the settings of the policy as text, keyed by their name in the config file
+func (p Policy) describe() map[string]string {
+	selector := ""
+	if p.Selector != nil {
+		selector = p.Selector.String()
+	}
+
+	return map[string]string{
+		"selector":        selector,
+		"enabled":         fmt.Sprint(p.Enabled),
+		"whitelistHours":  p.Whitelist.whitelist,
+		"blacklistHours":  p.Whitelist.blacklist,
+		"drainTimeout":    fmt.Sprint(p.DrainTimeout),
+		"killBudget":      fmt.Sprint(p.KillBudget),
+		"expiryPlanning":  p.ExpiryPlanning,
+		"minimumLifetime": p.MinimumLifetime.String(),
+		"maximumLifetime": p.MaximumLifetime.String(),
+
+		"minimumReadyNodes":    fmt.Sprint(p.MinimumReadyNodes),
+		"scaleDownGracePeriod": p.ScaleDownGracePeriod.String(),
+		"killUnhealthy":        fmt.Sprint(p.KillUnhealthy),
+		"unhealthyGracePeriod": p.UnhealthyGracePeriod.String(),
+		"maximumGracePeriod":   p.MaximumGracePeriod.String(),
+		"drainTimeoutAction":   p.DrainTimeoutAction,
+		"deleteGracePeriod":    p.DeleteGracePeriod.String(),
+		"deleteIgnorePDBs":     fmt.Sprint(p.DeleteIgnorePDBs),
+		"localStoragePods":     p.LocalStoragePods,
+		"barePods":             p.BarePods,
+	}
+}
+
+// sources of the policies
+const (
+	policySourceCluster    = "cluster"
+	policySourceConfig     = "config"
+	policySourceKillPolicy = "killpolicy"
+)
+
+// getMaximumDrainDuration returns the longest draining a node can take with the policy: the drain timeout, extended up
+// to the maximum grace period for pods with a longer termination grace period, and the grace period of the pods
+// deleted when it times out
+func (p Policy) getMaximumDrainDuration() time.Duration {
+	drainDuration := time.Duration(p.DrainTimeout) * time.Second
+	if p.MaximumGracePeriod > drainDuration {
+		drainDuration = p.MaximumGracePeriod
+	}
+	if p.DrainTimeoutAction == drainTimeoutActionDelete {
+		drainDuration += p.DeleteGracePeriod
+	}
+
+	return drainDuration
+}
+
+// getKey identifies the policy by its source and name
+func (p Policy) getKey() string {
+	return p.Source + "/" + p.Name
+}
+
+// newDefaultPolicy returns the policy defined by the command line flags
+func newDefaultPolicy() (policy Policy, err error) {
+	whitelistInstance, err := NewWhitelistInstance(*whitelist, *blacklist)
+	if err != nil {
+		return
+	}
+
+	return Policy{
+		Name:            "default",
+		Source:          policySourceCluster,
+		Enabled:         true,
+		Whitelist:       whitelistInstance,
+		DrainTimeout:    *drainTimeout,
+		KillBudget:      *killBudget,
+		ExpiryPlanning:  *expiryPlanning,
+		MinimumLifetime: *minimumLifetime,
+		MaximumLifetime: *maximumLifetime,
+
+		MinimumReadyNodes:    *minimumReadyNodes,
+		ScaleDownGracePeriod: *scaleDownGracePeriod,
+		KillUnhealthy:        *killUnhealthy,
+		UnhealthyGracePeriod: *unhealthyGracePeriod,
+		MaximumGracePeriod:   *maximumGracePeriod,
+		DrainTimeoutAction:   *drainTimeoutAction,
+		DeleteGracePeriod:    *deleteGracePeriod,
+		DeleteIgnorePDBs:     *deleteIgnorePDBs,
+		LocalStoragePods:     *localStoragePods,
+		BarePods:             *barePods,
+	}, nil
+}
+
+// getPolicy returns the policy of the cluster, applying its settings on top of the given default policy
+func (c ClusterConfig) getPolicy(defaultPolicy Policy) (Policy, error) {
+	return c.PolicySettings.apply(defaultPolicy)
+}
+
+// getNodePoolPolicies returns the node pool policies of the cluster followed by the given shared ones, applying their
+// settings on top of the policy of the cluster
+func (c ClusterConfig) getNodePoolPolicies(clusterPolicy Policy, sharedPolicies []PolicyConfig) (policies []Policy, err error) {
+	for _, policyConfig := range append(append([]PolicyConfig{}, c.Policies...), sharedPolicies...) {
+		var policy Policy
+		policy, err = policyConfig.getPolicy(clusterPolicy)
+		if err != nil {
+			return
+		}
+		policy.Source = policySourceConfig
+		policies = append(policies, policy)
+	}
+
+	return
+}
+
+// getPolicy returns the node pool policy, applying its settings on top of the given cluster policy
+func (p PolicyConfig) getPolicy(clusterPolicy Policy) (policy Policy, err error) {
+	selector, err := p.getSelector()
+	if err != nil {
+		return
+	}
+
+	policy, err = p.PolicySettings.apply(clusterPolicy)
+	if err != nil {
+		err = fmt.Errorf("policy %v: %v", p.Name, err)
+		return
+	}
+	policy.Name = p.Name
+	policy.Selector = selector
+
+	return
+}
+
+// getSelector returns the selector matching the nodes of the node pool and label selector of the policy
+func (p PolicyConfig) getSelector() (selector labels.Selector, err error) {
+	selector, err = labels.Parse(p.Selector)
+	if err != nil {
+		return
+	}
+
+	if p.NodePool != "" {
+		var requirement *labels.Requirement
+		requirement, err = labels.NewRequirement(labelGKENodePool, selection.Equals, []string{p.NodePool})
+		if err != nil {
+			return
+		}
+		selector = selector.Add(*requirement)
+	}
+
+	return
+}
+
+// apply returns the given policy with the settings that are set overridden
+func (s PolicySettings) apply(basePolicy Policy) (policy Policy, err error) {
+	policy = basePolicy
+
+	if s.Enabled != nil {
+		policy.Enabled = *s.Enabled
+	}
+
+	whitelistHours := basePolicy.Whitelist.whitelist
+	if s.WhitelistHours != nil {
+		whitelistHours = *s.WhitelistHours
+	}
+	blacklistHours := basePolicy.Whitelist.blacklist
+	if s.BlacklistHours != nil {
+		blacklistHours = *s.BlacklistHours
+	}
+	if s.WhitelistHours != nil || s.BlacklistHours != nil {
+		policy.Whitelist, err = NewWhitelistInstance(whitelistHours, blacklistHours)
+		if err != nil {
+			return
+		}
+	}
+
+	if s.DrainTimeout != nil {
+		if *s.DrainTimeout < 0 {
+			err = fmt.Errorf("drain timeout %d should not be negative", *s.DrainTimeout)
+			return
+		}
+		policy.DrainTimeout = *s.DrainTimeout
+	}
+	if s.KillBudget != nil {
+		if *s.KillBudget < 0 {
+			err = fmt.Errorf("kill budget %d should not be negative", *s.KillBudget)
+			return
+		}
+		policy.KillBudget = *s.KillBudget
+	}
+	if s.ExpiryPlanning != nil {
+		if *s.ExpiryPlanning != "random" && *s.ExpiryPlanning != "spread" {
+			err = fmt.Errorf("expiry planning '%v' should be random or spread", *s.ExpiryPlanning)
+			return
+		}
+		policy.ExpiryPlanning = *s.ExpiryPlanning
+	}
+	for _, d := range []struct {
+		setting  string
+		duration *metav1.Duration
+	}{
+		{"minimum lifetime", s.MinimumLifetime},
+		{"maximum lifetime", s.MaximumLifetime},
+		{"scale down grace period", s.ScaleDownGracePeriod},
+		{"unhealthy grace period", s.UnhealthyGracePeriod},
+		{"maximum grace period", s.MaximumGracePeriod},
+		{"delete grace period", s.DeleteGracePeriod},
+	} {
+		if d.duration != nil && d.duration.Duration < 0 {
+			err = fmt.Errorf("%v %v should not be negative", d.setting, d.duration.Duration)
+			return
+		}
+	}
+	if s.MinimumLifetime != nil {
+		policy.MinimumLifetime = s.MinimumLifetime.Duration
+	}
+	if s.MaximumLifetime != nil {
+		policy.MaximumLifetime = s.MaximumLifetime.Duration
+	}
+	if s.MinimumReadyNodes != nil {
+		if *s.MinimumReadyNodes < 0 {
+			err = fmt.Errorf("minimum ready nodes %d should not be negative", *s.MinimumReadyNodes)
+			return
+		}
+		policy.MinimumReadyNodes = *s.MinimumReadyNodes
+	}
+	if s.ScaleDownGracePeriod != nil {
+		policy.ScaleDownGracePeriod = s.ScaleDownGracePeriod.Duration
+	}
+	if s.KillUnhealthy != nil {
+		policy.KillUnhealthy = *s.KillUnhealthy
+	}
+	if s.UnhealthyGracePeriod != nil {
+		policy.UnhealthyGracePeriod = s.UnhealthyGracePeriod.Duration
+	}
+	if s.MaximumGracePeriod != nil {
+		policy.MaximumGracePeriod = s.MaximumGracePeriod.Duration
+	}
+	if s.DrainTimeoutAction != nil {
+		switch *s.DrainTimeoutAction {
+		case drainTimeoutActionProceed, drainTimeoutActionAbort, drainTimeoutActionDelete:
+		default:
+			err = fmt.Errorf("drain timeout action '%v' should be proceed, abort or delete", *s.DrainTimeoutAction)
+			return
+		}
+		policy.DrainTimeoutAction = *s.DrainTimeoutAction
+	}
+	if s.DeleteGracePeriod != nil {
+		policy.DeleteGracePeriod = s.DeleteGracePeriod.Duration
+	}
+	if s.DeleteIgnorePDBs != nil {
+		policy.DeleteIgnorePDBs = *s.DeleteIgnorePDBs
+	}
+	if s.LocalStoragePods != nil {
+		policy.LocalStoragePods, err = parseUnsafePodsAction("local storage pods", *s.LocalStoragePods)
+		if err != nil {
+			return
+		}
+	}
+	if s.BarePods != nil {
+		policy.BarePods, err = parseUnsafePodsAction("bare pods", *s.BarePods)
+		if err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// validate checks the settings can be applied on top of any policy
+func (s PolicySettings) validate() error {
+	_, err := s.apply(Policy{})
+	return err
+}
+
+// parseUnsafePodsAction checks the action for pods with local storage or without a controller
+func parseUnsafePodsAction(setting string, action string) (string, error) {
+	switch action {
+	case unsafePodsActionProceed, unsafePodsActionPostpone, unsafePodsActionRefuse:
+		return action, nil
+	}
+
+	return "", fmt.Errorf("%v '%v' should be proceed, postpone or refuse", setting, action)
+}
+
+// getInterval returns the time in second to wait between each node check of the cluster, falling back to the given
+// default interval
+func (c ClusterConfig) getInterval(defaultInterval int) int {
+	if c.Interval != nil {
+		return *c.Interval
+	}
+	return defaultInterval
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	config := `clusters:
+- name: production
+  context: gke_project_europe-west1_production
+  filters: "cloud.google.com/gke-nodepool: preemptible"
+  whitelistHours: "09:00 - 17:00"
+  drainTimeout: 600
+  killBudget: 2
+- name: staging
+  kubeconfig: /etc/kubeconfigs/staging
+`
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := readConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("Expected config file to be read, got %v", err)
+	}
+
+	if len(c.Clusters) != 2 {
+		t.Fatalf("Expected 2 clusters, got %d", len(c.Clusters))
+	}
+
+	if c.Clusters[0].Name != "production" || c.Clusters[0].Context != "gke_project_europe-west1_production" {
+		t.Errorf("Expected cluster production with its context, got %v with context %v", c.Clusters[0].Name, c.Clusters[0].Context)
+	}
+
+	if c.Clusters[1].Kubeconfig != "/etc/kubeconfigs/staging" || c.Clusters[1].DrainTimeout != nil {
+		t.Errorf("Expected cluster staging with kubeconfig and no drain timeout, got %v", c.Clusters[1])
+	}
+}
+
+func TestReadConfigFile_Invalid(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(configPath, []byte("clusters:\n- context: unnamed\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := readConfigFile(configPath)
+	if err == nil {
+		t.Errorf("Expected error for cluster without name")
+	}
+
+	if err := os.WriteFile(configPath, []byte("clusters:\n- name: typo\n  drainTimeot: 10\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = readConfigFile(configPath)
+	if err == nil {
+		t.Errorf("Expected error for unknown setting")
+	}
+}
+
+func TestClusterConfigGetPolicy(t *testing.T) {
+	defaultPolicy := newTestPolicy()
+	whitelistHours := "09:00 - 12:00"
+	drainTimeout := 600
+
+	cluster := ClusterConfig{
+		Name: "production",
+		PolicySettings: PolicySettings{
+			WhitelistHours: &whitelistHours,
+			DrainTimeout:   &drainTimeout,
+		},
+	}
+
+	policy, err := cluster.getPolicy(defaultPolicy)
+	if err != nil {
+		t.Fatalf("Expected policy to be valid, got %v", err)
+	}
+
+	if policy.DrainTimeout != 600 {
+		t.Errorf("Expected drain timeout 600, got %d", policy.DrainTimeout)
+	}
+	if policy.Whitelist.whitelistSecondCount != 3*3600 {
+		t.Errorf("Expected 3 hours of whitelist, got %d seconds", policy.Whitelist.whitelistSecondCount)
+	}
+	if policy.KillBudget != defaultPolicy.KillBudget || policy.MaximumLifetime != defaultPolicy.MaximumLifetime {
+		t.Errorf("Expected unset settings to fall back to the default policy, got %v", policy)
+	}
+}
+
+func TestReadConfigFile_Policies(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	config := `policies:
+- name: batch
+  nodePool: batch
+  minimumLifetime: 2h
+  maximumLifetime: 6h
+  expiryPlanning: spread
+- name: critical
+  selector: "team in (payments, checkout)"
+  enabled: false
+`
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := readConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("Expected config file to be read, got %v", err)
+	}
+
+	settings, err := c.getClusterSettings(ClusterConfig{}, newTestPolicy())
+	policies := settings.policies
+	if err != nil {
+		t.Fatalf("Expected policies to be valid, got %v", err)
+	}
+
+	if len(policies) != 2 {
+		t.Fatalf("Expected 2 policies, got %d", len(policies))
+	}
+
+	batch := policies[0]
+	if batch.MinimumLifetime != 2*time.Hour || batch.MaximumLifetime != 6*time.Hour || batch.ExpiryPlanning != "spread" || !batch.Enabled {
+		t.Errorf("Expected batch policy with lifetimes of 2h to 6h and spread planning, got %v", batch)
+	}
+	if !batch.Selector.Matches(labels.Set{"cloud.google.com/gke-nodepool": "batch"}) || batch.Selector.Matches(labels.Set{"cloud.google.com/gke-nodepool": "default"}) {
+		t.Errorf("Expected batch policy to only match the batch node pool, got selector %v", batch.Selector)
+	}
+
+	critical := policies[1]
+	if critical.Enabled || critical.DrainTimeout != *drainTimeout {
+		t.Errorf("Expected critical policy to be disabled and fall back to the default drain timeout, got %v", critical)
+	}
+	if !critical.Selector.Matches(labels.Set{"team": "payments"}) {
+		t.Errorf("Expected critical policy to match team payments, got selector %v", critical.Selector)
+	}
+}
+
+func TestReadConfigFile_InvalidPolicies(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+
+	configs := map[string]string{
+		"no name":            "policies:\n- nodePool: batch\n",
+		"no match":           "policies:\n- name: batch\n",
+		"invalid selector":   "policies:\n- name: batch\n  selector: \"team in payments\"\n",
+		"invalid planning":   "policies:\n- name: batch\n  nodePool: batch\n  expiryPlanning: evenly\n",
+		"invalid in cluster": "clusters:\n- name: production\n  policies:\n  - name: batch\n",
+	}
+
+	for description, config := range configs {
+		if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := readConfigFile(configPath)
+		if err == nil {
+			t.Errorf("Expected error for %v", description)
+		}
+	}
+}
+
+func TestClusterSettingsDiff(t *testing.T) {
+	previous, err := Config{}.getClusterSettings(ClusterConfig{}, newTestPolicy())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interval := 60
+	drainTimeout := 900
+	config := Config{
+		Interval:       &interval,
+		PolicySettings: PolicySettings{DrainTimeout: &drainTimeout},
+		Policies:       []PolicyConfig{{Name: "batch", NodePool: "batch"}},
+	}
+	settings, err := config.getClusterSettings(ClusterConfig{}, newTestPolicy())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes := settings.diff(previous)
+
+	expected := []string{
+		"interval: 600 -> 60",
+		"policy default: drainTimeout: 300 -> 900",
+		"policy batch: added",
+	}
+	if strings.Join(changes, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected changes %v, got %v", expected, changes)
+	}
+
+	if len(previous.diff(previous)) != 0 {
+		t.Errorf("Expected no changes between equal settings, got %v", previous.diff(previous))
+	}
+}
+
+func TestReadConfigFile_DrainPhases(t *testing.T) {
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	config := `drainPhases:
+- name: ingress
+  namespaces: ingress
+- name: dns
+  namespaces: kube-system
+  selector: k8s-app=kube-dns
+clusters:
+- name: production
+  drainPhases:
+  - name: rest
+`
+	if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := readConfigFile(configPath)
+	if err != nil {
+		t.Fatalf("Expected config to be valid, got %v", err)
+	}
+
+	if len(c.DrainPhases) != 2 || c.DrainPhases[1].Name != "dns" || c.DrainPhases[1].Selector != "k8s-app=kube-dns" {
+		t.Errorf("Expected drain phases ingress and dns, got %v", c.DrainPhases)
+	}
+
+	options, err := c.Clusters[0].DrainConfig.apply(c.DrainConfig).getDrainOptions()
+	if err != nil {
+		t.Fatalf("Expected drain options to be valid, got %v", err)
+	}
+	if len(options.Phases) != 1 || options.Phases[0].Name != "rest" {
+		t.Errorf("Expected the drain phases of the cluster to replace the top level ones, got %v", options.Phases)
+	}
+}
@@ -0,0 +1,613 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// DrainConfig configures which pods are evicted when draining a node, in the config file or with the command line
+// flags
+type DrainConfig struct {
+	DrainExcludeNamespaces *string            `json:"drainExcludeNamespaces,omitempty"`
+	DrainExcludePods       *string            `json:"drainExcludePods,omitempty"`
+	DrainIncludePods       *string            `json:"drainIncludePods,omitempty"`
+	DrainSystemNamespaces  *string            `json:"drainSystemNamespaces,omitempty"`
+	DrainPhases            []DrainPhaseConfig `json:"drainPhases,omitempty"`
+	DrainEvictionRetries   *int               `json:"drainEvictionRetries,omitempty"`
+}
+
+// DrainPhaseConfig configures a phase of the drain, evicting the pods of the namespaces that match the selector; unset
+// namespaces and selector match all pods
+type DrainPhaseConfig struct {
+	Name       string `json:"name"`
+	Namespaces string `json:"namespaces,omitempty"`
+	Selector   string `json:"selector,omitempty"`
+}
+
+// DrainOptions selects the pods to evict when draining a node and the order to evict them in
+type DrainOptions struct {
+	// ExcludeNamespaces lists the namespaces whose pods are left on the node
+	ExcludeNamespaces []string
+
+	// ExcludePods selects the pods left on the node by their labels
+	ExcludePods labels.Selector
+
+	// IncludePods selects the pods that are evicted even when their namespace or labels exclude them
+	IncludePods labels.Selector
+
+	// Phases are evicted one after the other, each pod in the first phase matching it; the pods matching none of the
+	// phases are evicted before the first phase
+	Phases []DrainPhase
+
+	// EvictionRetries is the number of times the eviction of a pod is retried while it's refused, 0 for no limit
+	EvictionRetries int
+
+	// MaximumGracePeriod is the longest termination grace period pods get, from the policy of the node
+	MaximumGracePeriod time.Duration
+
+	// TimeoutAction is what happens to the pods left when the drain times out: proceed, abort or delete, from the
+	// policy of the node
+	TimeoutAction string
+
+	// DeleteGracePeriod is the grace period of the pods deleted after the drain timeout
+	DeleteGracePeriod time.Duration
+
+	// DeleteIgnorePDBs deletes pods after the drain timeout even when their disruption budget allows no disruption
+	DeleteIgnorePDBs bool
+
+	// LocalStoragePods and BarePods are what happens when pods with emptyDir volumes or without a controller are on
+	// the node, as their data or the pods themselves are lost: proceed, postpone or refuse, from the policy of the node
+	LocalStoragePods string
+	BarePods         string
+}
+
+// DrainPhase selects the pods evicted in a phase of the drain
+type DrainPhase struct {
+	Name string
+
+	// Namespaces lists the namespaces of the pods of the phase, all namespaces when empty
+	Namespaces []string
+
+	// Pods selects the pods of the phase by their labels
+	Pods labels.Selector
+}
+
+// matches returns whether a pod is evicted in the phase
+func (p DrainPhase) matches(pod v1.Pod) bool {
+	if len(p.Namespaces) > 0 && !containsString(p.Namespaces, pod.ObjectMeta.Namespace) {
+		return false
+	}
+
+	return p.Pods == nil || p.Pods.Matches(labels.Set(pod.ObjectMeta.Labels))
+}
+
+// actions when pods with local storage or without a controller are on a node to drain
+const (
+	unsafePodsActionProceed  = "proceed"
+	unsafePodsActionPostpone = "postpone"
+	unsafePodsActionRefuse   = "refuse"
+)
+
+// actions when draining a node times out
+const (
+	drainTimeoutActionProceed = "proceed"
+	drainTimeoutActionAbort   = "abort"
+	drainTimeoutActionDelete  = "delete"
+)
+
+// DrainOutcome tells how draining a node ended
+type DrainOutcome string
+
+const (
+	// DrainOutcomeDrained is the outcome when all pods left the node before the timeout
+	DrainOutcomeDrained DrainOutcome = "drained"
+
+	// DrainOutcomeProceeded is the outcome when pods were left on the node at the timeout and it's killed anyway
+	DrainOutcomeProceeded DrainOutcome = "proceeded"
+
+	// DrainOutcomeAborted is the outcome when pods were left on the node at the timeout and it's kept
+	DrainOutcomeAborted DrainOutcome = "aborted"
+
+	// DrainOutcomeDeleted is the outcome when the pods left on the node at the timeout were deleted
+	DrainOutcomeDeleted DrainOutcome = "deleted"
+
+	// DrainOutcomePostponed is the outcome when the drain didn't start for pods with local storage or without a
+	// controller, and the kill is retried later
+	DrainOutcomePostponed DrainOutcome = "postponed"
+
+	// DrainOutcomeRefused is the outcome when the drain didn't start for pods with local storage or without a
+	// controller, and the node is kept
+	DrainOutcomeRefused DrainOutcome = "refused"
+
+	// DrainOutcomeFailed is the outcome when the drain stopped on an error, like the pods on the node that couldn't be
+	// listed; pods that can't be evicted are left for the timeout action instead
+	DrainOutcomeFailed DrainOutcome = "failed"
+)
+
+// DrainResult reports how draining a node went
+type DrainResult struct {
+	Outcome DrainOutcome
+
+	// RemainingPods lists the pods still on the node at the timeout, as namespace/name
+	RemainingPods []string
+
+	// LocalStoragePods and BarePods list the pods with emptyDir volumes and the pods without a controller, as
+	// namespace/name
+	LocalStoragePods []string
+	BarePods         []string
+
+	// EvictedPods and GonePods list the pods that were evicted and the pods that were already gone when evicting them,
+	// as namespace/name
+	EvictedPods []string
+	GonePods    []string
+
+	// BlockedPods holds why the eviction of the pods still on the node was refused, like the pod disruption budget
+	// blocking it, keyed by namespace/name
+	BlockedPods map[string]string
+
+	// FailedPods holds the errors of the pods that couldn't be evicted, keyed by namespace/name
+	FailedPods map[string]string
+
+	// Duration is the time the drain took
+	Duration time.Duration
+}
+
+// String summarises the result for logs and events
+func (r DrainResult) String() string {
+	return fmt.Sprintf("%d pod(s) evicted, %d already gone, %d blocked, %d failed and %d timed out in %v", len(r.EvictedPods), len(r.GonePods), len(r.BlockedPods), len(r.FailedPods), len(r.RemainingPods), r.Duration)
+}
+
+// evictionSettings are the settings the pods of a drain are evicted with
+type evictionSettings struct {
+	// deadline is the end of the drain, pods don't get a grace period beyond it
+	deadline           time.Time
+	maximumGracePeriod time.Duration
+
+	// retries is the number of times the eviction of a pod is retried while it's refused, 0 for no limit
+	retries int
+}
+
+// backoff bounds of retrying a refused eviction
+const (
+	evictionBackoffInitial = 5 * time.Second
+	evictionBackoffMaximum = 2 * time.Minute
+)
+
+// getEvictionBackoff returns the time to wait before retrying an eviction that has been refused a number of times,
+// doubling from the initial backoff up to the maximum
+func getEvictionBackoff(refusals int) time.Duration {
+	backoff := evictionBackoffInitial
+	for i := 1; i < refusals && backoff < evictionBackoffMaximum; i++ {
+		backoff *= 2
+	}
+	if backoff > evictionBackoffMaximum {
+		backoff = evictionBackoffMaximum
+	}
+
+	return backoff
+}
+
+// drainReport collects what happened to the evicted pods, safe for concurrent use by the evicting goroutines
+type drainReport struct {
+	mutex   sync.Mutex
+	evicted []string
+	gone    []string
+	blocked map[string]string
+	failed  map[string]string
+}
+
+func newDrainReport() *drainReport {
+	return &drainReport{blocked: map[string]string{}, failed: map[string]string{}}
+}
+
+func (r *drainReport) setEvicted(pod v1.Pod) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.blocked, pod.Namespace+"/"+pod.Name)
+	r.evicted = append(r.evicted, pod.Namespace+"/"+pod.Name)
+}
+
+func (r *drainReport) setGone(pod v1.Pod) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.blocked, pod.Namespace+"/"+pod.Name)
+	r.gone = append(r.gone, pod.Namespace+"/"+pod.Name)
+}
+
+// setBlocked records why the eviction of a pod is refused
+func (r *drainReport) setBlocked(pod v1.Pod, reason string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.blocked[pod.Namespace+"/"+pod.Name] = reason
+}
+
+func (r *drainReport) setFailed(pod v1.Pod, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.blocked, pod.Namespace+"/"+pod.Name)
+	r.failed[pod.Namespace+"/"+pod.Name] = err.Error()
+}
+
+// apply adds the collected pods to the result, with the blocked pods limited to those still on the node
+func (r *drainReport) apply(result *DrainResult) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	result.EvictedPods = append(result.EvictedPods, r.evicted...)
+	result.GonePods = append(result.GonePods, r.gone...)
+	for _, pod := range result.RemainingPods {
+		if reason, ok := r.blocked[pod]; ok {
+			if result.BlockedPods == nil {
+				result.BlockedPods = map[string]string{}
+			}
+			result.BlockedPods[pod] = reason
+		}
+	}
+	for pod, err := range r.failed {
+		if result.FailedPods == nil {
+			result.FailedPods = map[string]string{}
+		}
+		result.FailedPods[pod] = err
+	}
+}
+
+// newDefaultDrainConfig returns the drain settings defined by the command line flags
+func newDefaultDrainConfig() DrainConfig {
+	return DrainConfig{
+		DrainExcludeNamespaces: drainExcludeNamespaces,
+		DrainExcludePods:       drainExcludePods,
+		DrainIncludePods:       drainIncludePods,
+		DrainSystemNamespaces:  drainSystemNamespaces,
+		DrainEvictionRetries:   drainEvictionRetries,
+	}
+}
+
+// apply returns the given drain settings with the settings that are set overridden
+func (d DrainConfig) apply(baseConfig DrainConfig) (config DrainConfig) {
+	config = baseConfig
+
+	if d.DrainExcludeNamespaces != nil {
+		config.DrainExcludeNamespaces = d.DrainExcludeNamespaces
+	}
+	if d.DrainExcludePods != nil {
+		config.DrainExcludePods = d.DrainExcludePods
+	}
+	if d.DrainIncludePods != nil {
+		config.DrainIncludePods = d.DrainIncludePods
+	}
+	if d.DrainSystemNamespaces != nil {
+		config.DrainSystemNamespaces = d.DrainSystemNamespaces
+	}
+	if d.DrainPhases != nil {
+		config.DrainPhases = d.DrainPhases
+	}
+	if d.DrainEvictionRetries != nil {
+		config.DrainEvictionRetries = d.DrainEvictionRetries
+	}
+
+	return
+}
+
+// getDrainOptions parses the drain settings, unset settings evict all pods in a single phase; without drain phases the
+// pods of the system namespaces are evicted in a phase after the other pods
+func (d DrainConfig) getDrainOptions() (options DrainOptions, err error) {
+	options.ExcludePods = labels.Nothing()
+	options.IncludePods = labels.Nothing()
+
+	if d.DrainExcludeNamespaces != nil {
+		options.ExcludeNamespaces = parseList(*d.DrainExcludeNamespaces)
+	}
+
+	if d.DrainExcludePods != nil && strings.TrimSpace(*d.DrainExcludePods) != "" {
+		options.ExcludePods, err = labels.Parse(*d.DrainExcludePods)
+		if err != nil {
+			err = fmt.Errorf("drain exclude pods '%v' should be a label selector: %v", *d.DrainExcludePods, err)
+			return
+		}
+	}
+
+	if d.DrainIncludePods != nil && strings.TrimSpace(*d.DrainIncludePods) != "" {
+		options.IncludePods, err = labels.Parse(*d.DrainIncludePods)
+		if err != nil {
+			err = fmt.Errorf("drain include pods '%v' should be a label selector: %v", *d.DrainIncludePods, err)
+			return
+		}
+	}
+
+	if d.DrainPhases != nil {
+		for i, phaseConfig := range d.DrainPhases {
+			phase := DrainPhase{
+				Name:       strings.TrimSpace(phaseConfig.Name),
+				Namespaces: parseList(phaseConfig.Namespaces),
+				Pods:       labels.Everything(),
+			}
+			if phase.Name == "" {
+				err = fmt.Errorf("drain phase %d should have a name", i+1)
+				return
+			}
+			if strings.TrimSpace(phaseConfig.Selector) != "" {
+				phase.Pods, err = labels.Parse(phaseConfig.Selector)
+				if err != nil {
+					err = fmt.Errorf("drain phase %v selector '%v' should be a label selector: %v", phase.Name, phaseConfig.Selector, err)
+					return
+				}
+			}
+			options.Phases = append(options.Phases, phase)
+		}
+	} else if d.DrainSystemNamespaces != nil {
+		if systemNamespaces := parseList(*d.DrainSystemNamespaces); len(systemNamespaces) > 0 {
+			options.Phases = []DrainPhase{{Name: "system", Namespaces: systemNamespaces, Pods: labels.Everything()}}
+		}
+	}
+
+	if d.DrainEvictionRetries != nil {
+		if *d.DrainEvictionRetries < 0 {
+			err = fmt.Errorf("drain eviction retries %d should not be negative", *d.DrainEvictionRetries)
+			return
+		}
+		options.EvictionRetries = *d.DrainEvictionRetries
+	}
+
+	return
+}
+
+// parseList parses a comma separated list, ignoring spaces and empty items
+func parseList(list string) (items []string) {
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return
+}
+
+// isExcluded returns whether a pod is left on the node when draining it
+func (o DrainOptions) isExcluded(pod v1.Pod) bool {
+	podLabels := labels.Set(pod.ObjectMeta.Labels)
+	if o.IncludePods != nil && o.IncludePods.Matches(podLabels) {
+		return false
+	}
+	if o.ExcludePods != nil && o.ExcludePods.Matches(podLabels) {
+		return true
+	}
+
+	return containsString(o.ExcludeNamespaces, pod.ObjectMeta.Namespace)
+}
+
+// selectPods returns the pods to evict per phase, starting with the pods matching none of the phases
+func (o DrainOptions) selectPods(pods []v1.Pod) (phasePods [][]v1.Pod) {
+	phasePods = make([][]v1.Pod, len(o.Phases)+1)
+	for _, pod := range pods {
+		if o.isExcluded(pod) {
+			continue
+		}
+		phase := 0
+		for i, p := range o.Phases {
+			if p.matches(pod) {
+				phase = i + 1
+				break
+			}
+		}
+		phasePods[phase] = append(phasePods[phase], pod)
+	}
+
+	return
+}
+
+// getPhaseName returns the name of a phase returned by selectPods
+func (o DrainOptions) getPhaseName(phase int) string {
+	if phase == 0 {
+		return "default"
+	}
+
+	return o.Phases[phase-1].Name
+}
+
+// priorities of the built-in priority classes, which pods only carry in their spec when priority admission is enabled
+const (
+	systemClusterCriticalPriority int32 = 2000000000
+	systemNodeCriticalPriority    int32 = 2000001000
+)
+
+// podDrainOrder is the position of a pod in the order pods are evicted from a node
+type podDrainOrder struct {
+	critical bool
+	order    int
+	priority int32
+}
+
+// getPodDrainOrder returns whether a pod is critical, its order from its drain order annotation, 0 when it has none,
+// and its priority
+func getPodDrainOrder(pod v1.Pod) (drainOrder podDrainOrder, err error) {
+	drainOrder.priority = getPodPriority(pod)
+	drainOrder.critical = drainOrder.priority >= systemClusterCriticalPriority
+
+	if value, ok := pod.ObjectMeta.Annotations[annotationGKEPreemptibleKillerDrainOrder]; ok {
+		drainOrder.order, err = strconv.Atoi(value)
+		if err != nil {
+			err = fmt.Errorf("pod %v/%v has drain order '%v', should be an integer", pod.ObjectMeta.Namespace, pod.ObjectMeta.Name, value)
+			return
+		}
+	}
+
+	return
+}
+
+// getPodPriority returns the priority of a pod, from its spec or else from the built-in critical priority classes
+func getPodPriority(pod v1.Pod) int32 {
+	if pod.Spec.Priority != nil {
+		return *pod.Spec.Priority
+	}
+
+	switch pod.Spec.PriorityClassName {
+	case "system-cluster-critical":
+		return systemClusterCriticalPriority
+	case "system-node-critical":
+		return systemNodeCriticalPriority
+	}
+
+	return 0
+}
+
+// groupPodsByDrainOrder splits pods into the groups to evict one after the other: critical pods last regardless of
+// their annotation, otherwise by their drain order annotation first, then by priority so lower priority pods leave
+// first
+func groupPodsByDrainOrder(pods []v1.Pod) (groups [][]v1.Pod, errs []error) {
+	podsPerOrder := map[podDrainOrder][]v1.Pod{}
+	var orders []podDrainOrder
+	for _, pod := range pods {
+		drainOrder, err := getPodDrainOrder(pod)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		if _, ok := podsPerOrder[drainOrder]; !ok {
+			orders = append(orders, drainOrder)
+		}
+		podsPerOrder[drainOrder] = append(podsPerOrder[drainOrder], pod)
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		if orders[i].critical != orders[j].critical {
+			return !orders[i].critical
+		}
+		if orders[i].order != orders[j].order {
+			return orders[i].order < orders[j].order
+		}
+		return orders[i].priority < orders[j].priority
+	})
+
+	for _, drainOrder := range orders {
+		groups = append(groups, podsPerOrder[drainOrder])
+	}
+
+	return
+}
+
+// hasLocalStorage returns whether a pod has emptyDir volumes, whose data is lost when the pod leaves the node
+func hasLocalStorage(pod v1.Pod) bool {
+	for _, volume := range pod.Spec.Volumes {
+		if volume.EmptyDir != nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isBarePod returns whether a pod has no controller to recreate it when it's evicted
+func isBarePod(pod v1.Pod) bool {
+	return metav1.GetControllerOf(&pod) == nil
+}
+
+// checkUnsafePods lists the pods with local storage and without a controller, and returns the outcome of the drain
+// when the actions for these pods keep it from starting
+func (o DrainOptions) checkUnsafePods(pods []v1.Pod) (result DrainResult) {
+	actions := map[string]bool{}
+	for _, pod := range pods {
+		if hasLocalStorage(pod) {
+			result.LocalStoragePods = append(result.LocalStoragePods, pod.Namespace+"/"+pod.Name)
+			actions[o.LocalStoragePods] = true
+		}
+		if isBarePod(pod) {
+			result.BarePods = append(result.BarePods, pod.Namespace+"/"+pod.Name)
+			actions[o.BarePods] = true
+		}
+	}
+
+	if actions[unsafePodsActionRefuse] {
+		result.Outcome = DrainOutcomeRefused
+	} else if actions[unsafePodsActionPostpone] {
+		result.Outcome = DrainOutcomePostponed
+	}
+
+	return
+}
+
+// getTerminationGracePeriod returns the termination grace period a pod requests
+func getTerminationGracePeriod(pod v1.Pod) time.Duration {
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		return time.Duration(*pod.Spec.TerminationGracePeriodSeconds) * time.Second
+	}
+
+	return v1.DefaultTerminationGracePeriodSeconds * time.Second
+}
+
+// getDrainDuration returns the time to wait for pods to leave a node: the drain timeout, extended to the longest
+// termination grace period of the pods up to the maximum grace period
+func getDrainDuration(drainTimeout time.Duration, pods []v1.Pod, maximumGracePeriod time.Duration) time.Duration {
+	drainDuration := drainTimeout
+	for _, pod := range pods {
+		gracePeriod := getTerminationGracePeriod(pod)
+		if gracePeriod > maximumGracePeriod {
+			gracePeriod = maximumGracePeriod
+		}
+		if gracePeriod > drainDuration {
+			drainDuration = gracePeriod
+		}
+	}
+
+	return drainDuration
+}
+
+// getGracePeriod returns the grace period to evict a pod with: the grace period it requests, shortened to the maximum
+// grace period and the time left to drain the node, but at least a second
+func getGracePeriod(pod v1.Pod, maximumGracePeriod time.Duration, remaining time.Duration) time.Duration {
+	gracePeriod := getTerminationGracePeriod(pod)
+	if gracePeriod > maximumGracePeriod {
+		gracePeriod = maximumGracePeriod
+	}
+	if gracePeriod > remaining {
+		gracePeriod = remaining.Truncate(time.Second)
+	}
+	if gracePeriod < time.Second {
+		gracePeriod = time.Second
+	}
+
+	return gracePeriod
+}
+
+// String describes the drain options in the form of the command line flags
+func (o DrainOptions) String() string {
+	phases := []string{}
+	for _, phase := range o.Phases {
+		phases = append(phases, fmt.Sprintf("%v (namespaces [%v], pods [%v])", phase.Name, strings.Join(phase.Namespaces, ", "), phase.Pods))
+	}
+
+	return fmt.Sprintf("exclude namespaces [%v], exclude pods [%v], include pods [%v], phases [%v], eviction retries %v", strings.Join(o.ExcludeNamespaces, ", "), o.ExcludePods, o.IncludePods, strings.Join(phases, ", "), o.EvictionRetries)
+}
+
+// containsString returns whether a list contains a string
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
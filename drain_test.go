@@ -0,0 +1,273 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestDrainOptionsSelectPods(t *testing.T) {
+	excludeNamespaces := "monitoring, logging"
+	excludePods := "app=cache"
+	includePods := "k8s-app=fluentd"
+	systemNamespaces := "kube-system"
+
+	options, err := DrainConfig{
+		DrainExcludeNamespaces: &excludeNamespaces,
+		DrainExcludePods:       &excludePods,
+		DrainIncludePods:       &includePods,
+		DrainSystemNamespaces:  &systemNamespaces,
+	}.getDrainOptions()
+	if err != nil {
+		t.Fatalf("Expect drain options to be valid, instead got %v", err)
+	}
+
+	pods := []v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", Labels: map[string]string{"app": "web"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default", Labels: map[string]string{"app": "cache"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "prometheus", Namespace: "monitoring"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "fluentd", Namespace: "logging", Labels: map[string]string{"k8s-app": "fluentd"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system"}},
+	}
+
+	phasePods := options.selectPods(pods)
+
+	if len(phasePods) != 2 {
+		t.Fatalf("Expect pods to be evicted in 2 phases, instead got %d", len(phasePods))
+	}
+	if len(phasePods[0]) != 2 || phasePods[0][0].Name != "web" || phasePods[0][1].Name != "fluentd" {
+		t.Errorf("Expect pods web and fluentd to be evicted first, instead got %v", phasePods[0])
+	}
+	if len(phasePods[1]) != 1 || phasePods[1][0].Name != "kube-dns" {
+		t.Errorf("Expect pod kube-dns to be evicted last, instead got %v", phasePods[1])
+	}
+}
+
+func TestDrainOptionsSelectPods_Phases(t *testing.T) {
+	systemNamespaces := "kube-system"
+
+	options, err := DrainConfig{
+		DrainSystemNamespaces: &systemNamespaces,
+		DrainPhases: []DrainPhaseConfig{
+			{Name: "ingress", Namespaces: "ingress"},
+			{Name: "dns", Namespaces: "kube-system", Selector: "k8s-app=kube-dns"},
+			{Name: "rest"},
+		},
+	}.getDrainOptions()
+	if err != nil {
+		t.Fatalf("Expect drain options to be valid, instead got %v", err)
+	}
+
+	pods := []v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "kube-dns", Namespace: "kube-system", Labels: map[string]string{"k8s-app": "kube-dns"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "nginx", Namespace: "ingress"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "metrics-server", Namespace: "kube-system"}},
+	}
+
+	phasePods := options.selectPods(pods)
+
+	// the phases replace the system namespaces, and no pod is left for the phase of pods matching none
+	expected := [][]string{{}, {"nginx"}, {"kube-dns"}, {"web", "metrics-server"}}
+	if len(phasePods) != len(expected) {
+		t.Fatalf("Expect pods to be evicted in %d phases, instead got %d", len(expected), len(phasePods))
+	}
+	for phase, names := range expected {
+		actual := []string{}
+		for _, pod := range phasePods[phase] {
+			actual = append(actual, pod.Name)
+		}
+		if fmt.Sprint(actual) != fmt.Sprint(names) {
+			t.Errorf("Expect phase %v to evict %v, instead got %v", options.getPhaseName(phase), names, actual)
+		}
+	}
+}
+
+func TestDrainConfigGetDrainOptions_Invalid(t *testing.T) {
+	excludePods := "app in cache"
+
+	_, err := DrainConfig{DrainExcludePods: &excludePods}.getDrainOptions()
+	if err == nil {
+		t.Errorf("Expect error for invalid label selector")
+	}
+}
+
+func TestDrainConfigGetDrainOptions_InvalidPhases(t *testing.T) {
+	configs := []DrainConfig{
+		{DrainPhases: []DrainPhaseConfig{{Name: "dns", Selector: "k8s-app in kube-dns"}}},
+		{DrainPhases: []DrainPhaseConfig{{Namespaces: "kube-system"}}},
+	}
+
+	for _, config := range configs {
+		_, err := config.getDrainOptions()
+		if err == nil {
+			t.Errorf("Expect error for invalid drain phases %v", config.DrainPhases)
+		}
+	}
+}
+
+func TestGroupPodsByDrainOrder(t *testing.T) {
+	lowPriority := int32(-10)
+	highPriority := int32(1000)
+
+	pods := []v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "coredns"}, Spec: v1.PodSpec{PriorityClassName: "system-cluster-critical"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "web"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "database", Annotations: map[string]string{"estafette.io/gke-preemptible-killer-drain-order": "10"}}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "batch"}, Spec: v1.PodSpec{Priority: &lowPriority}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "api"}, Spec: v1.PodSpec{Priority: &highPriority}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "worker"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "typo", Annotations: map[string]string{"estafette.io/gke-preemptible-killer-drain-order": "last"}}},
+	}
+
+	groups, errs := groupPodsByDrainOrder(pods)
+
+	if len(errs) != 1 {
+		t.Errorf("Expect 1 error for the invalid drain order, instead got %v", errs)
+	}
+
+	var names [][]string
+	for _, group := range groups {
+		var groupNames []string
+		for _, pod := range group {
+			groupNames = append(groupNames, pod.Name)
+		}
+		names = append(names, groupNames)
+	}
+
+	expected := "[[batch] [web worker typo] [api] [database] [coredns]]"
+	if fmt.Sprint(names) != expected {
+		t.Errorf("Expect groups %v, instead got %v", expected, names)
+	}
+}
+
+func TestGroupPodsByDrainOrder_CriticalLast(t *testing.T) {
+	lowPriority := int32(-10)
+	priorities := []int32{10, 100, 1000}
+
+	pods := []v1.Pod{
+		{ObjectMeta: metav1.ObjectMeta{Name: "kube-proxy"}, Spec: v1.PodSpec{PriorityClassName: "system-node-critical"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "backup", Annotations: map[string]string{"estafette.io/gke-preemptible-killer-drain-order": "5"}}, Spec: v1.PodSpec{Priority: &lowPriority}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "api-10"}, Spec: v1.PodSpec{Priority: &priorities[0]}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "api-100"}, Spec: v1.PodSpec{Priority: &priorities[1]}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "api-1000"}, Spec: v1.PodSpec{Priority: &priorities[2]}},
+	}
+
+	groups, _ := groupPodsByDrainOrder(pods)
+
+	var names [][]string
+	for _, group := range groups {
+		var groupNames []string
+		for _, pod := range group {
+			groupNames = append(groupNames, pod.Name)
+		}
+		names = append(names, groupNames)
+	}
+
+	// the annotated low priority pod still leaves before the critical pod, and each priority leaves after the lower ones
+	expected := "[[api-10] [api-100] [api-1000] [backup] [kube-proxy]]"
+	if fmt.Sprint(names) != expected {
+		t.Errorf("Expect groups %v, instead got %v", expected, names)
+	}
+}
+
+func TestGetDrainDuration(t *testing.T) {
+	shortGracePeriod := int64(60)
+	longGracePeriod := int64(900)
+
+	pods := []v1.Pod{
+		{Spec: v1.PodSpec{}},
+		{Spec: v1.PodSpec{TerminationGracePeriodSeconds: &shortGracePeriod}},
+		{Spec: v1.PodSpec{TerminationGracePeriodSeconds: &longGracePeriod}},
+	}
+
+	if duration := getDrainDuration(300*time.Second, pods[:2], 10*time.Minute); duration != 300*time.Second {
+		t.Errorf("Expect the drain timeout when it covers all grace periods, instead got %v", duration)
+	}
+	if duration := getDrainDuration(300*time.Second, pods, 20*time.Minute); duration != 900*time.Second {
+		t.Errorf("Expect the longest grace period, instead got %v", duration)
+	}
+	if duration := getDrainDuration(300*time.Second, pods, 10*time.Minute); duration != 10*time.Minute {
+		t.Errorf("Expect the maximum grace period, instead got %v", duration)
+	}
+}
+
+func TestGetGracePeriod(t *testing.T) {
+	gracePeriodSeconds := int64(120)
+	pod := v1.Pod{Spec: v1.PodSpec{TerminationGracePeriodSeconds: &gracePeriodSeconds}}
+
+	cases := []struct {
+		maximumGracePeriod time.Duration
+		remaining          time.Duration
+		expected           time.Duration
+	}{
+		{10 * time.Minute, 10 * time.Minute, 120 * time.Second},
+		{time.Minute, 10 * time.Minute, time.Minute},
+		{10 * time.Minute, 90500 * time.Millisecond, 90 * time.Second},
+		{10 * time.Minute, -time.Minute, time.Second},
+	}
+
+	for _, c := range cases {
+		if gracePeriod := getGracePeriod(pod, c.maximumGracePeriod, c.remaining); gracePeriod != c.expected {
+			t.Errorf("Expect grace period %v with maximum %v and %v remaining, instead got %v", c.expected, c.maximumGracePeriod, c.remaining, gracePeriod)
+		}
+	}
+}
+
+func TestCheckUnsafePods(t *testing.T) {
+	controller := true
+	pods := []v1.Pod{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web", Controller: &controller}}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "cache", Namespace: "default", OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "cache", Controller: &controller}}},
+			Spec:       v1.PodSpec{Volumes: []v1.Volume{{Name: "data", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}}}},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "default"},
+		},
+	}
+
+	cases := []struct {
+		localStoragePods string
+		barePods         string
+		expected         DrainOutcome
+	}{
+		{"proceed", "proceed", ""},
+		{"postpone", "proceed", DrainOutcomePostponed},
+		{"postpone", "refuse", DrainOutcomeRefused},
+		{"proceed", "postpone", DrainOutcomePostponed},
+	}
+
+	for _, c := range cases {
+		result := DrainOptions{LocalStoragePods: c.localStoragePods, BarePods: c.barePods}.checkUnsafePods(pods)
+
+		if result.Outcome != c.expected {
+			t.Errorf("Expect outcome '%v' with local storage pods %v and bare pods %v, instead got '%v'", c.expected, c.localStoragePods, c.barePods, result.Outcome)
+		}
+		if fmt.Sprint(result.LocalStoragePods) != "[default/cache]" || fmt.Sprint(result.BarePods) != "[default/debug]" {
+			t.Errorf("Expect pod default/cache with local storage and bare pod default/debug, instead got %v and %v", result.LocalStoragePods, result.BarePods)
+		}
+	}
+}
+
+func TestGetEvictionBackoff(t *testing.T) {
+	cases := map[int]time.Duration{
+		1:  5 * time.Second,
+		2:  10 * time.Second,
+		3:  20 * time.Second,
+		5:  80 * time.Second,
+		6:  2 * time.Minute,
+		50: 2 * time.Minute,
+	}
+
+	for refusals, expected := range cases {
+		if backoff := getEvictionBackoff(refusals); backoff != expected {
+			t.Errorf("Expect backoff after %d refusals to be %v, instead got %v", refusals, expected, backoff)
+		}
+	}
+}
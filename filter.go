@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/selection"
+)
+
+// FilterConfig configures the filters of the nodes to process, in the config file or with the command line flags
+type FilterConfig struct {
+	Filters           *string `json:"filters,omitempty"`
+	TaintFilters      *string `json:"taintFilters,omitempty"`
+	ConditionFilters  *string `json:"conditionFilters,omitempty"`
+	AnnotationFilters *string `json:"annotationFilters,omitempty"`
+}
+
+// NodeFilter selects the preemptible nodes to process
+type NodeFilter struct {
+	// Selector selects nodes by their labels, in addition to the preemptible label
+	Selector labels.Selector
+
+	// Taints are the taints nodes need to have, or not to have when excluded
+	Taints []TaintFilter
+
+	// Conditions selects nodes by the status of their conditions, with the condition types as keys
+	Conditions labels.Selector
+
+	// Annotations selects nodes by their annotations
+	Annotations labels.Selector
+}
+
+// TaintFilter matches the taints with a key, and an effect unless empty
+type TaintFilter struct {
+	Key     string
+	Effect  v1.TaintEffect
+	Exclude bool
+}
+
+// newDefaultFilterConfig returns the filters defined by the command line flags
+func newDefaultFilterConfig() FilterConfig {
+	return FilterConfig{
+		Filters:           filters,
+		TaintFilters:      taintFilters,
+		ConditionFilters:  conditionFilters,
+		AnnotationFilters: annotationFilters,
+	}
+}
+
+// apply returns the given filters with the filters that are set overridden
+func (f FilterConfig) apply(baseConfig FilterConfig) (config FilterConfig) {
+	config = baseConfig
+
+	if f.Filters != nil {
+		config.Filters = f.Filters
+	}
+	if f.TaintFilters != nil {
+		config.TaintFilters = f.TaintFilters
+	}
+	if f.ConditionFilters != nil {
+		config.ConditionFilters = f.ConditionFilters
+	}
+	if f.AnnotationFilters != nil {
+		config.AnnotationFilters = f.AnnotationFilters
+	}
+
+	return
+}
+
+// getNodeFilter parses the filters, unset filters match all nodes
+func (f FilterConfig) getNodeFilter() (filter NodeFilter, err error) {
+	if f.Filters != nil {
+		filter, err = parseFilters(*f.Filters)
+		if err != nil {
+			return
+		}
+	}
+
+	if f.TaintFilters != nil {
+		filter.Taints, err = parseTaintFilters(*f.TaintFilters)
+		if err != nil {
+			return
+		}
+	}
+
+	if f.ConditionFilters != nil {
+		filter.Conditions, err = labels.Parse(*f.ConditionFilters)
+		if err != nil {
+			err = fmt.Errorf("condition filters '%v' should be a selector on the status of node conditions: %v", *f.ConditionFilters, err)
+			return
+		}
+	}
+
+	if f.AnnotationFilters != nil {
+		filter.Annotations, err = labels.Parse(*f.AnnotationFilters)
+		if err != nil {
+			err = fmt.Errorf("annotation filters '%v' should be a selector on the annotations: %v", *f.AnnotationFilters, err)
+			return
+		}
+	}
+
+	return
+}
+
+// parseFilters parses filters in the Kubernetes label selector syntax, like `key1 in (value1, value2), key2 notin
+// (value3), !key3`, or in the legacy form of `key1: value1[, value2[, ...]][; key2: value3[, value4[, ...]], ...]`
+func parseFilters(filters string) (filter NodeFilter, err error) {
+	// a colon is neither valid in a label selector nor in a label key or value
+	if strings.Contains(filters, ":") {
+		return parseLegacyFilters(filters)
+	}
+
+	filter.Selector, err = labels.Parse(filters)
+	if err != nil {
+		err = fmt.Errorf("filters '%v' should be a label selector: %v", filters, err)
+		return
+	}
+
+	return
+}
+
+// parseLegacyFilters parses label filters in the form of `key1: value1[, value2[, ...]][; key2: value3[, value4[,
+// ...]], ...]`, a node matches when it has one of the values for every key
+func parseLegacyFilters(filters string) (filter NodeFilter, err error) {
+	filter.Selector = labels.NewSelector()
+
+	filters = strings.Replace(filters, " ", "", -1)
+	pairs := strings.Split(filters, ";")
+	for _, pair := range pairs {
+		keyValue := strings.Split(pair, ":")
+
+		// Check format.
+		if len(keyValue) != 2 {
+			err = fmt.Errorf("filter '%v' should be of the form `label_key: label_value`", keyValue)
+			return
+		}
+
+		var requirement *labels.Requirement
+		requirement, err = labels.NewRequirement(keyValue[0], selection.In, strings.Split(keyValue[1], ","))
+		if err != nil {
+			return
+		}
+		filter.Selector = filter.Selector.Add(*requirement)
+	}
+
+	return
+}
+
+// parseTaintFilters parses taint filters in the form of `key1[:effect], !key2[:effect]`, where an exclamation mark
+// excludes the nodes with the taint
+func parseTaintFilters(taintFilters string) (taints []TaintFilter, err error) {
+	if strings.TrimSpace(taintFilters) == "" {
+		return
+	}
+
+	for _, taintFilter := range strings.Split(strings.Replace(taintFilters, " ", "", -1), ",") {
+		taint := TaintFilter{}
+
+		if strings.HasPrefix(taintFilter, "!") {
+			taint.Exclude = true
+			taintFilter = strings.TrimPrefix(taintFilter, "!")
+		}
+
+		keyEffect := strings.Split(taintFilter, ":")
+		if len(keyEffect) > 2 || keyEffect[0] == "" {
+			err = fmt.Errorf("taint filter '%v' should be of the form `[!]taint_key[:taint_effect]`", taintFilter)
+			return
+		}
+		taint.Key = keyEffect[0]
+
+		if len(keyEffect) == 2 {
+			taint.Effect = v1.TaintEffect(keyEffect[1])
+			switch taint.Effect {
+			case v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+			default:
+				err = fmt.Errorf("taint filter '%v' has effect '%v', should be NoSchedule, PreferNoSchedule or NoExecute", taintFilter, taint.Effect)
+				return
+			}
+		}
+
+		taints = append(taints, taint)
+	}
+
+	return
+}
+
+// Matches returns whether a node passes all filters
+func (f NodeFilter) Matches(node v1.Node) bool {
+	if f.Selector != nil && !f.Selector.Matches(labels.Set(node.ObjectMeta.Labels)) {
+		return false
+	}
+
+	for _, taint := range f.Taints {
+		if taint.matchesAny(node.Spec.Taints) == taint.Exclude {
+			return false
+		}
+	}
+
+	if f.Conditions != nil {
+		conditions := labels.Set{}
+		for _, condition := range node.Status.Conditions {
+			conditions[string(condition.Type)] = string(condition.Status)
+		}
+		if !f.Conditions.Matches(conditions) {
+			return false
+		}
+	}
+
+	if f.Annotations != nil && !f.Annotations.Matches(labels.Set(node.ObjectMeta.Annotations)) {
+		return false
+	}
+
+	return true
+}
+
+// matchesAny returns whether one of the given taints has the key and effect of the filter
+func (t TaintFilter) matchesAny(taints []v1.Taint) bool {
+	for _, taint := range taints {
+		if taint.Key == t.Key && (t.Effect == "" || taint.Effect == t.Effect) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// String returns the taint filter in the form it's parsed from
+func (t TaintFilter) String() string {
+	s := t.Key
+	if t.Exclude {
+		s = "!" + s
+	}
+	if t.Effect != "" {
+		s += ":" + string(t.Effect)
+	}
+	return s
+}
+
+// withLabel returns the filter narrowed down to the nodes with the given label
+func (f NodeFilter) withLabel(key, value string) (NodeFilter, error) {
+	requirement, err := labels.NewRequirement(key, selection.Equals, []string{value})
+	if err != nil {
+		return f, err
+	}
+
+	selector := f.Selector
+	if selector == nil {
+		selector = labels.NewSelector()
+	}
+	f.Selector = selector.Add(*requirement)
+
+	return f, nil
+}
+
+// String returns the label selector of the filter, followed by its other filters when set
+func (f NodeFilter) String() string {
+	s := ""
+	if f.Selector != nil {
+		s = f.Selector.String()
+	}
+
+	if len(f.Taints) > 0 {
+		taints := []string{}
+		for _, taint := range f.Taints {
+			taints = append(taints, taint.String())
+		}
+		s += fmt.Sprintf("; taints: %v", strings.Join(taints, ","))
+	}
+	if f.Conditions != nil && !f.Conditions.Empty() {
+		s += fmt.Sprintf("; conditions: %v", f.Conditions)
+	}
+	if f.Annotations != nil && !f.Annotations.Empty() {
+		s += fmt.Sprintf("; annotations: %v", f.Annotations)
+	}
+
+	return s
+}
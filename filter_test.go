@@ -0,0 +1,156 @@
+package main
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+func TestParseFilters(t *testing.T) {
+	filter, err := parseFilters("cloud.google.com/gke-nodepool: preemptible; team: estafette")
+	if err != nil {
+		t.Fatalf("Expected filters to parse, got %v", err)
+	}
+
+	if filter.String() != "cloud.google.com/gke-nodepool in (preemptible),team in (estafette)" {
+		t.Errorf("Expected 2 label filters, got %v", filter)
+	}
+
+	_, err = parseFilters("team")
+	if err != nil {
+		t.Errorf("Expected filter on existence of a label to parse, got %v", err)
+	}
+
+	_, err = parseFilters("team: estafette; owner")
+	if err == nil {
+		t.Errorf("Expected error for legacy filter without value")
+	}
+
+	_, err = parseFilters("team in estafette")
+	if err == nil {
+		t.Errorf("Expected error for invalid label selector")
+	}
+}
+
+func TestParseFilters_MultipleValues(t *testing.T) {
+	filter, err := parseFilters("cloud.google.com/gke-nodepool: pool-1, pool-2; team: estafette")
+	if err != nil {
+		t.Fatalf("Expected filters to parse, got %v", err)
+	}
+
+	if !filter.Selector.Matches(labels.Set{"cloud.google.com/gke-nodepool": "pool-2", "team": "estafette"}) {
+		t.Errorf("Expected filter %v to match the second value of a key", filter)
+	}
+	if filter.Selector.Matches(labels.Set{"cloud.google.com/gke-nodepool": "pool-3", "team": "estafette"}) {
+		t.Errorf("Expected filter %v not to match another value", filter)
+	}
+}
+
+func TestParseFilters_Exclusion(t *testing.T) {
+	filter, err := parseFilters("cloud.google.com/gke-nodepool notin (gpu, batch), !dedicated, team != payments")
+	if err != nil {
+		t.Fatalf("Expected filters to parse, got %v", err)
+	}
+
+	nodes := map[string]bool{
+		"default":   true,
+		"gpu":       false,
+		"dedicated": false,
+		"payments":  false,
+	}
+	nodeLabels := map[string]labels.Set{
+		"default":   {"cloud.google.com/gke-nodepool": "default", "team": "estafette"},
+		"gpu":       {"cloud.google.com/gke-nodepool": "gpu"},
+		"dedicated": {"cloud.google.com/gke-nodepool": "default", "dedicated": "ml"},
+		"payments":  {"cloud.google.com/gke-nodepool": "default", "team": "payments"},
+	}
+
+	for node, expected := range nodes {
+		if filter.Selector.Matches(nodeLabels[node]) != expected {
+			t.Errorf("Expected filter %v to match node %v: %v", filter, node, expected)
+		}
+	}
+}
+
+func TestNodeFilterWithLabel(t *testing.T) {
+	filter, _ := parseFilters("team notin (payments)")
+
+	poolFilter, err := filter.withLabel("cloud.google.com/gke-nodepool", "pool-1")
+	if err != nil {
+		t.Fatalf("Expected label to be added, got %v", err)
+	}
+
+	if poolFilter.String() != "cloud.google.com/gke-nodepool=pool-1,team notin (payments)" {
+		t.Errorf("Expected filter on node pool and team, got %v", poolFilter)
+	}
+	if filter.String() != "team notin (payments)" {
+		t.Errorf("Expected original filter to be unchanged, got %v", filter)
+	}
+}
+
+func TestParseTaintFilters(t *testing.T) {
+	taints, err := parseTaintFilters("nvidia.com/gpu, !dedicated:NoSchedule")
+	if err != nil {
+		t.Fatalf("Expected taint filters to parse, got %v", err)
+	}
+
+	if len(taints) != 2 || taints[0] != (TaintFilter{Key: "nvidia.com/gpu"}) || taints[1] != (TaintFilter{Key: "dedicated", Effect: v1.TaintEffectNoSchedule, Exclude: true}) {
+		t.Errorf("Expected a required gpu taint and an excluded dedicated taint, got %v", taints)
+	}
+
+	for _, taintFilters := range []string{"dedicated:Never", "!", "dedicated:NoSchedule:NoExecute"} {
+		_, err = parseTaintFilters(taintFilters)
+		if err == nil {
+			t.Errorf("Expected error for taint filters '%v'", taintFilters)
+		}
+	}
+}
+
+func TestNodeFilterMatches(t *testing.T) {
+	taintFilters := "!nvidia.com/gpu"
+	conditionFilters := "Ready=True, DiskPressure!=True"
+	annotationFilters := "!estafette.io/keep"
+
+	filter, err := FilterConfig{
+		TaintFilters:      &taintFilters,
+		ConditionFilters:  &conditionFilters,
+		AnnotationFilters: &annotationFilters,
+	}.getNodeFilter()
+	if err != nil {
+		t.Fatalf("Expected filters to parse, got %v", err)
+	}
+
+	ready := []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}}
+
+	nodes := map[string]v1.Node{
+		"healthy": {
+			Status: v1.NodeStatus{Conditions: ready},
+		},
+		"gpu": {
+			Spec:   v1.NodeSpec{Taints: []v1.Taint{{Key: "nvidia.com/gpu", Effect: v1.TaintEffectNoSchedule}}},
+			Status: v1.NodeStatus{Conditions: ready},
+		},
+		"not ready": {
+			Status: v1.NodeStatus{Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionFalse}}},
+		},
+		"disk pressure": {
+			Status: v1.NodeStatus{Conditions: append([]v1.NodeCondition{{Type: v1.NodeDiskPressure, Status: v1.ConditionTrue}}, ready...)},
+		},
+		"kept": {
+			ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"estafette.io/keep": "true"}},
+			Status:     v1.NodeStatus{Conditions: ready},
+		},
+	}
+
+	for description, node := range nodes {
+		if filter.Matches(node) != (description == "healthy") {
+			t.Errorf("Expected filter %v to match node %v: %v", filter, description, description == "healthy")
+		}
+	}
+
+	if (NodeFilter{}).Matches(nodes["gpu"]) != true {
+		t.Errorf("Expected empty filter to match all nodes")
+	}
+}
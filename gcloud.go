@@ -16,10 +16,14 @@ type GCloud struct {
 	Zone      string
 }
 
+//go:generate mockgen -package=main -destination ./gcloud_mock.go -source=gcloud.go
 type GCloudClient interface {
 	DeleteNode(string) error
 }
 
+// newGCloudClient creates the GCloud client used to delete instances, it can be replaced in tests
+var newGCloudClient = NewGCloudClient
+
 // NewGCloudClient return a GCloud client
 func NewGCloudClient(projectId string, zone string) (gcloud GCloudClient, err error) {
 	client, err := google.DefaultClient(context.Background(), compute.ComputeScope)
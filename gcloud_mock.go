@@ -0,0 +1,48 @@
+// Code generated by MockGen. DO NOT EDIT.
+// Source: gcloud.go
+
+// Package main is a generated GoMock package.
+package main
+
+import (
+	reflect "reflect"
+
+	gomock "github.com/golang/mock/gomock"
+)
+
+// MockGCloudClient is a mock of GCloudClient interface.
+type MockGCloudClient struct {
+	ctrl     *gomock.Controller
+	recorder *MockGCloudClientMockRecorder
+}
+
+// MockGCloudClientMockRecorder is the mock recorder for MockGCloudClient.
+type MockGCloudClientMockRecorder struct {
+	mock *MockGCloudClient
+}
+
+// NewMockGCloudClient creates a new mock instance.
+func NewMockGCloudClient(ctrl *gomock.Controller) *MockGCloudClient {
+	mock := &MockGCloudClient{ctrl: ctrl}
+	mock.recorder = &MockGCloudClientMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockGCloudClient) EXPECT() *MockGCloudClientMockRecorder {
+	return m.recorder
+}
+
+// DeleteNode mocks base method.
+func (m *MockGCloudClient) DeleteNode(arg0 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteNode", arg0)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteNode indicates an expected call of DeleteNode.
+func (mr *MockGCloudClientMockRecorder) DeleteNode(arg0 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteNode", reflect.TypeOf((*MockGCloudClient)(nil).DeleteNode), arg0)
+}
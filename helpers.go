@@ -1,15 +1,81 @@
 package main
 
 import (
+	"hash/fnv"
 	"math/rand"
+	"sync"
 	"time"
 )
 
-// seed random number
-var R = rand.New(rand.NewSource(time.Now().UnixNano()))
+// Clock provides the current time and waits for time to pass, so it can be controlled in tests and simulations
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+// NewRealClock returns a Clock backed by the system time
+func NewRealClock() Clock {
+	return &realClock{}
+}
+
+type realClock struct{}
+
+func (c *realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (c *realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (c *realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+// Random is a source of random numbers, so randomness can be reproduced in tests and incident reviews
+type Random interface {
+	Intn(n int) int
+	Int63n(n int64) int64
+}
+
+// NewRandom returns a Random seeded with the given seed, safe for concurrent use
+func NewRandom(seed int64) Random {
+	return rand.New(&lockedSource{source: rand.NewSource(seed)})
+}
+
+// NewNodeRandom returns a Random seeded from a node name combined with a seed, so the same node always draws the
+// same random numbers
+func NewNodeRandom(nodeName string, seed int64) Random {
+	hash := fnv.New64a()
+	hash.Write([]byte(nodeName))
+
+	return NewRandom(int64(hash.Sum64()) ^ seed)
+}
+
+// lockedSource guards a rand.Source with a mutex, as the sources from math/rand are not safe for concurrent use
+type lockedSource struct {
+	mutex  sync.Mutex
+	source rand.Source
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.source.Int63()
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.source.Seed(seed)
+}
 
 // ApplyJitter return a random number
-func ApplyJitter(input int) (output int) {
+func ApplyJitter(random Random, input int) (output int) {
 	deviation := int(0.25 * float64(input))
-	return input - deviation + R.Intn(2*deviation)
+	if deviation <= 0 {
+		return input
+	}
+	return input - deviation + random.Intn(2*deviation)
 }
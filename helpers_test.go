@@ -1,15 +1,119 @@
 package main
 
 import (
-	"math/rand"
+	"runtime"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestApplyJitter(t *testing.T) {
-	R = rand.New(rand.NewSource(0))
-
-	var output = ApplyJitter(100)
+	var output = ApplyJitter(NewRandom(0), 100)
 	if output != 99 {
 		t.Errorf("ApplyJitter, expected 99 got %d", output)
 	}
+
+	// inputs too small to deviate are returned as is instead of panicking
+	for _, input := range []int{0, 1, 3} {
+		if output := ApplyJitter(NewRandom(0), input); output != input {
+			t.Errorf("ApplyJitter, expected %d got %d", input, output)
+		}
+	}
+}
+
+func TestNewNodeRandom(t *testing.T) {
+	first := NewNodeRandom("node-1", 42).Int63n(1000000)
+	second := NewNodeRandom("node-1", 42).Int63n(1000000)
+	if first != second {
+		t.Errorf("NewNodeRandom, expected the same number for the same node and seed, got %d and %d", first, second)
+	}
+
+	other := NewNodeRandom("node-2", 42).Int63n(1000000)
+	if first == other {
+		t.Errorf("NewNodeRandom, expected a different number for another node, got %d twice", first)
+	}
+}
+
+// fakeClock is a Clock whose time only moves forward when advanced or slept on, firing the timers it passes
+type fakeClock struct {
+	mutex  sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	deadline time.Time
+	ch       chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.timers = append(c.timers, fakeTimer{deadline: c.now.Add(d), ch: ch})
+
+	return ch
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.Advance(d)
+	// give other goroutines waiting on the clock a chance to run
+	runtime.Gosched()
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.now = c.now.Add(d)
+
+	pendingTimers := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.deadline.After(c.now) {
+			pendingTimers = append(pendingTimers, timer)
+			continue
+		}
+		timer.ch <- c.now
+	}
+	c.timers = pendingTimers
+}
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2017, 11, 11, 12, 00, 00, 0, time.UTC)
+	clock := newFakeClock(start)
+
+	timer := clock.After(10 * time.Second)
+
+	clock.Sleep(5 * time.Second)
+	select {
+	case <-timer:
+		t.Errorf("FakeClock, expected timer not to fire after 5 seconds")
+	default:
+	}
+
+	clock.Sleep(5 * time.Second)
+	select {
+	case <-timer:
+	default:
+		t.Errorf("FakeClock, expected timer to fire after 10 seconds")
+	}
+
+	if !clock.Now().Equal(start.Add(10 * time.Second)) {
+		t.Errorf("FakeClock, expected time to be %s, got %s", start.Add(10*time.Second), clock.Now())
+	}
 }
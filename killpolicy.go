@@ -0,0 +1,52 @@
+package main
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// killPolicyResource is the cluster scoped custom resource declaring node pool policies at runtime
+var killPolicyResource = schema.GroupVersionResource{
+	Group:    "estafette.io",
+	Version:  "v1",
+	Resource: "preemptiblekillpolicies",
+}
+
+// PreemptibleKillPolicy is a node pool policy declared as custom resource
+type PreemptibleKillPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   PreemptibleKillPolicySpec   `json:"spec,omitempty"`
+	Status PreemptibleKillPolicyStatus `json:"status,omitempty"`
+}
+
+// PreemptibleKillPolicySpec selects the nodes of the policy and the settings that apply to them, the same way a
+// policy in the configuration file does
+type PreemptibleKillPolicySpec struct {
+	NodePool       string `json:"nodePool,omitempty"`
+	Selector       string `json:"selector,omitempty"`
+	PolicySettings `json:",inline"`
+}
+
+// PreemptibleKillPolicyStatus reports on the nodes the policy applied to during the last pass
+type PreemptibleKillPolicyStatus struct {
+	// NodesManaged is the number of nodes the policy applied to
+	NodesManaged int `json:"nodesManaged"`
+
+	// NextKill is the earliest expiry of the nodes the policy applied to
+	NextKill *metav1.Time `json:"nextKill,omitempty"`
+
+	// LastError is the last error of the policy or of processing one of its nodes
+	LastError string `json:"lastError,omitempty"`
+}
+
+// getPolicyConfig returns the policy config equivalent to the custom resource, named after it
+func (p PreemptibleKillPolicy) getPolicyConfig() PolicyConfig {
+	return PolicyConfig{
+		Name:           p.ObjectMeta.Name,
+		NodePool:       p.Spec.NodePool,
+		Selector:       p.Spec.Selector,
+		PolicySettings: p.Spec.PolicySettings,
+	}
+}
@@ -4,40 +4,117 @@ import (
 	"context"
 	"fmt"
 	"math"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/rs/zerolog/log"
 	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	"k8s.io/api/policy/v1beta1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+
+	// support the gcp auth provider of kube configs created by gcloud
+	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 )
 
 //go:generate mockgen -package=main -destination ./kubernetes_client_mock.go -source=kubernetes_client.go
 type KubernetesClient interface {
-	DrainNode(ctx context.Context, nodeName string, drainTimeout int) (err error)
-	DrainKubeDNSFromNode(ctx context.Context, nodeName string, drainTimeout int) (err error)
+	DrainNode(ctx context.Context, nodeName string, drainTimeout int, options DrainOptions) (result DrainResult, err error)
 	GetNode(ctx context.Context, nodeName string) (node *v1.Node, err error)
 	DeleteNode(ctx context.Context, nodeName string) (err error)
-	GetPreemptibleNodes(ctx context.Context, filters map[string]string) (nodes *v1.NodeList, err error)
+	GetPreemptibleNodes(ctx context.Context, filter NodeFilter) (nodes *v1.NodeList, err error)
 	GetProjectIdAndZoneFromNode(ctx context.Context, nodeName string) (projectID string, zone string, err error)
 	SetNodeAnnotation(ctx context.Context, nodeName string, key string, value string) (err error)
+	RemoveNodeAnnotation(ctx context.Context, nodeName string, key string) (err error)
 	SetUnschedulableState(ctx context.Context, nodeName string, unschedulable bool) (err error)
+	GetKillPolicies(ctx context.Context) (policies []PreemptibleKillPolicy, err error)
+	UpdateKillPolicyStatus(ctx context.Context, policy PreemptibleKillPolicy) (err error)
+	CreateNodeEvent(ctx context.Context, node v1.Node, eventType string, reason string, message string) (err error)
+	GetBlockedPods(ctx context.Context, nodeName string, options DrainOptions) (blockedPods []string, err error)
+	GetUnsafePods(ctx context.Context, nodeName string, options DrainOptions) (result DrainResult, err error)
+}
+
+// NewKubeClientConfig returns the config to connect to the Kubernetes API, read from the kube config file(s) and
+// context when provided for out of cluster execution, or from the service account of the pod otherwise
+func NewKubeClientConfig(kubeConfigPath string, kubeContext string) (config *rest.Config, err error) {
+	if kubeConfigPath == "" && kubeContext == "" {
+		return rest.InClusterConfig()
+	}
+
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeConfigPath != "" {
+		loadingRules.Precedence = filepath.SplitList(kubeConfigPath)
+	}
+
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: kubeContext,
+	}
+
+	config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+	if err != nil {
+		err = fmt.Errorf("Error loading kube config %v with context '%v':\n%v", kubeConfigPath, kubeContext, err)
+		return
+	}
+
+	return
 }
 
 // NewKubernetesClient return a Kubernetes client
-func NewKubernetesClient(kubeClientset *kubernetes.Clientset) (kubernetes KubernetesClient, err error) {
+func NewKubernetesClient(kubeClientset kubernetes.Interface, dynamicClient dynamic.Interface, clock Clock, random Random) (kubernetes KubernetesClient, err error) {
+	evictionVersion, err := getEvictionVersion(kubeClientset.Discovery())
+	if err != nil {
+		return
+	}
+
 	return &kubernetesClient{
-		kubeClientset: kubeClientset,
+		kubeClientset:   kubeClientset,
+		dynamicClient:   dynamicClient,
+		clock:           clock,
+		random:          random,
+		evictionVersion: evictionVersion,
 	}, nil
 }
 
 type kubernetesClient struct {
-	kubeClientset *kubernetes.Clientset
+	kubeClientset   kubernetes.Interface
+	dynamicClient   dynamic.Interface
+	clock           Clock
+	random          Random
+	evictionVersion string
+}
+
+// getEvictionVersion returns the version of the policy API group the server evicts pods with, policy/v1 is available
+// since Kubernetes 1.22 and the only one left since 1.25, older servers fall back to policy/v1beta1
+func getEvictionVersion(discoveryClient discovery.DiscoveryInterface) (version string, err error) {
+	resources, err := discoveryClient.ServerResourcesForGroupVersion("v1")
+	if errors.IsNotFound(err) {
+		return "v1beta1", nil
+	}
+	if err != nil {
+		err = fmt.Errorf("Error discovering the eviction API version:\n%v", err)
+		return
+	}
+
+	for _, resource := range resources.APIResources {
+		if resource.Name == "pods/eviction" && resource.Group == "policy" && resource.Version == "v1" {
+			return "v1", nil
+		}
+	}
+
+	return "v1beta1", nil
 }
 
 // GetProjectIdAndZoneFromNode returns project id and zone from given node name
@@ -56,16 +133,13 @@ func (c *kubernetesClient) GetProjectIdAndZoneFromNode(ctx context.Context, node
 	return
 }
 
-// GetPreemptibleNodes return a list of preemptible node
-func (c *kubernetesClient) GetPreemptibleNodes(ctx context.Context, filters map[string]string) (nodes *v1.NodeList, err error) {
-
-	labelSelector := labels.Set{
-		"cloud.google.com/gke-preemptible": "true",
-	}
-
-	for key, value := range filters {
-		labelSelector[key] = value
+// GetPreemptibleNodes return a list of preemptible node matching the filter
+func (c *kubernetesClient) GetPreemptibleNodes(ctx context.Context, filter NodeFilter) (nodes *v1.NodeList, err error) {
+	preemptibleFilter, err := filter.withLabel("cloud.google.com/gke-preemptible", "true")
+	if err != nil {
+		return
 	}
+	labelSelector := preemptibleFilter.Selector
 
 	nodes, err = c.kubeClientset.CoreV1().Nodes().List(ctx, metav1.ListOptions{
 		LabelSelector: labelSelector.String(),
@@ -74,6 +148,15 @@ func (c *kubernetesClient) GetPreemptibleNodes(ctx context.Context, filters map[
 		return
 	}
 
+	// taints, conditions and annotations can't be selected on by the api
+	matchingNodes := []v1.Node{}
+	for _, node := range nodes.Items {
+		if filter.Matches(node) {
+			matchingNodes = append(matchingNodes, node)
+		}
+	}
+	nodes.Items = matchingNodes
+
 	return
 }
 
@@ -117,6 +200,29 @@ func (c *kubernetesClient) SetNodeAnnotation(ctx context.Context, nodeName strin
 	return
 }
 
+// RemoveNodeAnnotation removes an annotation from a node from a given node name, fetching the node before the update
+// like SetNodeAnnotation
+func (c *kubernetesClient) RemoveNodeAnnotation(ctx context.Context, nodeName string, key string) (err error) {
+	newNode, err := c.GetNode(ctx, nodeName)
+
+	if err != nil {
+		err = fmt.Errorf("Error getting node information before removing annotation:\n%v", err)
+		return
+	}
+
+	if _, ok := newNode.ObjectMeta.Annotations[key]; !ok {
+		return
+	}
+	delete(newNode.ObjectMeta.Annotations, key)
+
+	_, err = c.kubeClientset.CoreV1().Nodes().Update(ctx, newNode, metav1.UpdateOptions{})
+	if err != nil {
+		return
+	}
+
+	return
+}
+
 // SetUnschedulableState set the unschedulable state of a given node name
 func (c *kubernetesClient) SetUnschedulableState(ctx context.Context, nodeName string, unschedulable bool) (err error) {
 	node, err := c.GetNode(ctx, nodeName)
@@ -136,14 +242,68 @@ func (c *kubernetesClient) SetUnschedulableState(ctx context.Context, nodeName s
 	return
 }
 
+// GetKillPolicies returns the PreemptibleKillPolicy custom resources sorted by name
+func (c *kubernetesClient) GetKillPolicies(ctx context.Context) (policies []PreemptibleKillPolicy, err error) {
+	list, err := c.dynamicClient.Resource(killPolicyResource).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return
+	}
+
+	for _, item := range list.Items {
+		var policy PreemptibleKillPolicy
+		err = runtime.DefaultUnstructuredConverter.FromUnstructured(item.Object, &policy)
+		if err != nil {
+			err = fmt.Errorf("Error converting kill policy %v:\n%v", item.GetName(), err)
+			return
+		}
+		policies = append(policies, policy)
+	}
+
+	sort.Slice(policies, func(a, b int) bool {
+		return policies[a].ObjectMeta.Name < policies[b].ObjectMeta.Name
+	})
+
+	return
+}
+
+// UpdateKillPolicyStatus updates the status subresource of a PreemptibleKillPolicy custom resource
+func (c *kubernetesClient) UpdateKillPolicyStatus(ctx context.Context, policy PreemptibleKillPolicy) (err error) {
+	object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&policy)
+	if err != nil {
+		return
+	}
+
+	_, err = c.dynamicClient.Resource(killPolicyResource).UpdateStatus(ctx, &unstructured.Unstructured{Object: object}, metav1.UpdateOptions{})
+	if err != nil {
+		return
+	}
+
+	return
+}
+
 // filterOutPodByOwnerReferenceKind filter out a list of pods by its owner references kind
 func filterOutPodByOwnerReferenceKind(podList []v1.Pod, kind string) (output []v1.Pod) {
 	for _, pod := range podList {
+		owned := false
 		for _, ownerReference := range pod.ObjectMeta.OwnerReferences {
-			if ownerReference.Kind != kind {
-				output = append(output, pod)
+			if ownerReference.Kind == kind {
+				owned = true
 			}
 		}
+		if !owned {
+			output = append(output, pod)
+		}
+	}
+
+	return
+}
+
+// filterOutMirrorPods filters out the mirror pods of static pods, which are managed by the kubelet and can't be evicted
+func filterOutMirrorPods(podList []v1.Pod) (output []v1.Pod) {
+	for _, pod := range podList {
+		if _, ok := pod.ObjectMeta.Annotations[v1.MirrorPodAnnotationKey]; !ok {
+			output = append(output, pod)
+		}
 	}
 
 	return
@@ -162,295 +322,572 @@ func filterOutPodByNode(podList []v1.Pod, nodeName string) (output []v1.Pod) {
 
 // DrainNode delete every pods from a given node and wait that all pods are removed before it succeed
 // it also make sure we don't select DaemonSet because they are not subject to unschedulable state
-func (c *kubernetesClient) DrainNode(ctx context.Context, nodeName string, drainTimeout int) (err error) {
-	// Select all pods sitting on the node except the one from kube-system
+// when the drain times out the remaining pods are left, deleted or the drain is aborted depending on the timeout action
+func (c *kubernetesClient) DrainNode(ctx context.Context, nodeName string, drainTimeout int, options DrainOptions) (result DrainResult, err error) {
+	start := c.clock.Now()
+	report := newDrainReport()
+	defer func() {
+		report.apply(&result)
+		result.Duration = c.clock.Now().Sub(start)
+		if err != nil {
+			result.Outcome = DrainOutcomeFailed
+		}
+	}()
+
+	phasePods, pods, err := c.getPodsToDrain(ctx, nodeName, options)
+	if err != nil {
+		return
+	}
+
+	log.Ctx(ctx).Info().
+		Str("host", nodeName).
+		Msgf("%d pod(s) found", len(pods))
+
+	// pods with local storage and bare pods are lost when they leave the node, which can keep the drain from starting
+	result = options.checkUnsafePods(pods)
+	if len(result.LocalStoragePods) > 0 || len(result.BarePods) > 0 {
+		log.Ctx(ctx).Warn().
+			Str("host", nodeName).
+			Strs("localStoragePods", result.LocalStoragePods).
+			Strs("barePods", result.BarePods).
+			Msgf("%d pod(s) with local storage and %d pod(s) without controller found", len(result.LocalStoragePods), len(result.BarePods))
+	}
+	if result.Outcome != "" {
+		return
+	}
+
+	// give the pods their termination grace period, reporting those that get less than they request
+	drainDuration := getDrainDuration(time.Duration(drainTimeout)*time.Second, pods, options.MaximumGracePeriod)
+	for _, pod := range pods {
+		requested := getTerminationGracePeriod(pod)
+		if granted := getGracePeriod(pod, options.MaximumGracePeriod, drainDuration); granted < requested {
+			log.Ctx(ctx).Warn().
+				Str("host", nodeName).
+				Msgf("Pod %s/%s requests a termination grace period of %v, it will get at most %v", pod.Namespace, pod.Name, requested, granted)
+		}
+	}
+
+	// evict the pods phase by phase, so the components the pods of earlier phases depend on stay until they're gone, and
+	// within a phase the pods in drain order, waiting for each group of pods to be gone before evicting the next
+	settings := evictionSettings{
+		deadline:           c.clock.Now().Add(drainDuration),
+		maximumGracePeriod: options.MaximumGracePeriod,
+		retries:            options.EvictionRetries,
+	}
+	timeout := c.clock.After(drainDuration)
+	for phase, podsOfPhase := range phasePods {
+		if len(podsOfPhase) == 0 {
+			continue
+		}
+		log.Ctx(ctx).Info().
+			Str("host", nodeName).
+			Msgf("Draining %d pod(s) of phase %v", len(podsOfPhase), options.getPhaseName(phase))
+
+		groups, errs := groupPodsByDrainOrder(podsOfPhase)
+		for _, err := range errs {
+			log.Ctx(ctx).Warn().
+				Err(err).
+				Str("host", nodeName).
+				Msg("Invalid drain order, evicting the pod with drain order 0")
+		}
+
+		for _, group := range groups {
+			if !c.drainPods(ctx, nodeName, group, settings, report, timeout) {
+				if ctx.Err() != nil {
+					err = ctx.Err()
+					return
+				}
+				return c.handleDrainTimeout(ctx, nodeName, pods, options, result)
+			}
+		}
+	}
+
+	log.Ctx(ctx).Info().
+		Str("host", nodeName).
+		Msg("Done draining node")
+
+	result.Outcome = DrainOutcomeDrained
+
+	return
+}
 
-	fieldSelector := fmt.Sprintf("spec.nodeName=%v,metadata.namespace!=kube-system", nodeName)
+// handleDrainTimeout applies the timeout action to the pods that are still on the node when the drain times out, adding
+// them and the outcome to the result
+func (c *kubernetesClient) handleDrainTimeout(ctx context.Context, nodeName string, pods []v1.Pod, options DrainOptions, result DrainResult) (DrainResult, error) {
+	remainingPods, err := c.getRemainingPods(ctx, nodeName, pods)
+	if err != nil {
+		return result, err
+	}
+	for _, pod := range remainingPods {
+		result.RemainingPods = append(result.RemainingPods, pod.Namespace+"/"+pod.Name)
+	}
+
+	switch options.TimeoutAction {
+	case drainTimeoutActionAbort:
+		result.Outcome = DrainOutcomeAborted
+	case drainTimeoutActionDelete:
+		result.Outcome, err = c.deletePods(ctx, nodeName, remainingPods, options)
+	default:
+		result.Outcome = DrainOutcomeProceeded
+	}
+
+	log.Ctx(ctx).Warn().
+		Str("host", nodeName).
+		Strs("pods", result.RemainingPods).
+		Msgf("Draining node timeout reached with %d pod(s) remaining, %v", len(remainingPods), result.Outcome)
+
+	return result, err
+}
 
+// getRemainingPods returns the given pods that are still on the node
+func (c *kubernetesClient) getRemainingPods(ctx context.Context, nodeName string, pods []v1.Pod) (remainingPods []v1.Pod, err error) {
 	podList, err := c.kubeClientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{
-		FieldSelector: fieldSelector,
+		FieldSelector: fmt.Sprintf("spec.nodeName=%v", nodeName),
 	})
-
 	if err != nil {
 		return
 	}
 
-	// Filter out DaemonSet from the list of pods
-	filteredPodList := filterOutPodByOwnerReferenceKind(podList.Items, "DaemonSet")
+	podNames := map[string]bool{}
+	for _, pod := range pods {
+		podNames[pod.ObjectMeta.Namespace+"/"+pod.ObjectMeta.Name] = true
+	}
+	for _, pod := range filterOutPodByNode(podList.Items, nodeName) {
+		if podNames[pod.ObjectMeta.Namespace+"/"+pod.ObjectMeta.Name] {
+			remainingPods = append(remainingPods, pod)
+		}
+	}
 
-	log.Info().
-		Str("host", nodeName).
-		Msgf("%d pod(s) found", len(filteredPodList))
+	return
+}
 
-	stopEvicting := make(chan bool)
-	stopPolling := make(chan bool)
-	errCh := make(chan error)
-	defer func() {
-		if len(errCh) > 0 {
-			err = <-errCh
+// deletePods deletes the pods left on a node after the drain timeout with the delete grace period, bypassing their
+// disruption budgets; unless budgets can be ignored the drain is aborted when a budget allows no disruption of a pod
+func (c *kubernetesClient) deletePods(ctx context.Context, nodeName string, pods []v1.Pod, options DrainOptions) (outcome DrainOutcome, err error) {
+	if !options.DeleteIgnorePDBs {
+		var budgets map[string][]policyv1.PodDisruptionBudget
+		budgets, err = c.getBlockingPodDisruptionBudgets(ctx, pods)
+		if err != nil {
+			return
 		}
-	}()
+		for _, pod := range pods {
+			if podBudgets, ok := budgets[pod.Namespace+"/"+pod.Name]; ok {
+				log.Ctx(ctx).Warn().
+					Str("host", nodeName).
+					Msgf("Pod disruption %s can't allow the disruption of pod %s/%s, not deleting pods", getBudgetNames(podBudgets), pod.Namespace, pod.Name)
+				return DrainOutcomeAborted, nil
+			}
+		}
+	}
 
-	go func() {
-		if err := c.evictPods(ctx, filteredPodList, stopEvicting); err != nil {
-			errCh <- err
+	gracePeriodSeconds := int64(options.DeleteGracePeriod.Seconds())
+	for _, pod := range pods {
+		err = c.kubeClientset.CoreV1().Pods(pod.Namespace).Delete(ctx, pod.Name, metav1.DeleteOptions{
+			GracePeriodSeconds: &gracePeriodSeconds,
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			return
 		}
-	}()
+		err = nil
+	}
 
-	doneDraining := make(chan bool)
-	// Wait until all pods are deleted
-	go func() {
-		for {
-			sleepTime := ApplyJitter(10)
-			sleepDuration := time.Duration(sleepTime) * time.Second
-			pendingPodList, err := c.kubeClientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{
-				FieldSelector: fieldSelector,
-			})
+	// give the containers of the deleted pods their grace period before the node goes away
+	c.clock.Sleep(options.DeleteGracePeriod)
 
-			if err != nil {
-				log.Error().
-					Err(err).
-					Str("host", nodeName).
-					Msgf("Error getting list of pods, sleeping %ds", sleepTime)
+	return DrainOutcomeDeleted, nil
+}
 
-				time.Sleep(sleepDuration)
-				continue
-			}
+// getPodDisruptionBudgets returns the pod disruption budgets of a namespace, with the policy API version supported by
+// the server
+func (c *kubernetesClient) getPodDisruptionBudgets(ctx context.Context, namespace string) (budgets []policyv1.PodDisruptionBudget, err error) {
+	if c.evictionVersion == "v1" {
+		var budgetList *policyv1.PodDisruptionBudgetList
+		budgetList, err = c.kubeClientset.PolicyV1().PodDisruptionBudgets(namespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return
+		}
+		return budgetList.Items, nil
+	}
+
+	budgetList, err := c.kubeClientset.PolicyV1beta1().PodDisruptionBudgets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return
+	}
+	for _, budget := range budgetList.Items {
+		budgets = append(budgets, policyv1.PodDisruptionBudget{
+			ObjectMeta: budget.ObjectMeta,
+			Spec: policyv1.PodDisruptionBudgetSpec{
+				MinAvailable:   budget.Spec.MinAvailable,
+				Selector:       budget.Spec.Selector,
+				MaxUnavailable: budget.Spec.MaxUnavailable,
+			},
+			Status: policyv1.PodDisruptionBudgetStatus{
+				DisruptionsAllowed: budget.Status.DisruptionsAllowed,
+				CurrentHealthy:     budget.Status.CurrentHealthy,
+				DesiredHealthy:     budget.Status.DesiredHealthy,
+				ExpectedPods:       budget.Status.ExpectedPods,
+			},
+		})
+	}
 
-			// Filter out DaemonSet from the list of pods
-			filteredPendingPodList := filterOutPodByOwnerReferenceKind(pendingPodList.Items, "DaemonSet")
-			podsPending := len(filteredPendingPodList)
+	return
+}
 
-			if podsPending == 0 {
-				doneDraining <- true
+// getBlockingPodDisruptionBudgets returns the pod disruption budgets keeping the given pods from being evicted
+// together, keyed by the namespace/name of the pods they block: the budgets allowing less disruptions than they have
+// pods among the given ones and, as the API refuses to evict them, the budgets of pods covered by more than one
+func (c *kubernetesClient) getBlockingPodDisruptionBudgets(ctx context.Context, pods []v1.Pod) (blockingBudgets map[string][]policyv1.PodDisruptionBudget, err error) {
+	blockingBudgets = map[string][]policyv1.PodDisruptionBudget{}
+	budgetsPerNamespace := map[string][]policyv1.PodDisruptionBudget{}
+	podBudgets := map[string][]policyv1.PodDisruptionBudget{}
+	podsPerBudget := map[string]int{}
+
+	for _, pod := range pods {
+		budgets, ok := budgetsPerNamespace[pod.Namespace]
+		if !ok {
+			budgets, err = c.getPodDisruptionBudgets(ctx, pod.Namespace)
+			if err != nil {
 				return
 			}
+			budgetsPerNamespace[pod.Namespace] = budgets
+		}
 
-			log.Info().
-				Str("host", nodeName).
-				Msgf("%d pod(s) pending deletion, sleeping %ds", podsPending, sleepTime)
-
-			select {
-			case <-stopPolling:
+		for _, budget := range budgets {
+			var selector labels.Selector
+			selector, err = metav1.LabelSelectorAsSelector(budget.Spec.Selector)
+			if err != nil {
 				return
-			default:
-				time.Sleep(sleepDuration)
+			}
+			if selector.Matches(labels.Set(pod.ObjectMeta.Labels)) {
+				podBudgets[pod.Namespace+"/"+pod.Name] = append(podBudgets[pod.Namespace+"/"+pod.Name], budget)
+				podsPerBudget[budget.Namespace+"/"+budget.Name]++
 			}
 		}
-	}()
+	}
 
-	select {
-	case <-doneDraining:
-		break
-	case <-time.After(time.Duration(drainTimeout) * time.Second):
-		log.Warn().
-			Str("host", nodeName).
-			Msg("Draining node timeout reached")
-		close(stopPolling)
-		close(stopEvicting)
-		return
-	case <-ctx.Done():
-		close(stopPolling)
-		close(stopEvicting)
+	for pod, budgets := range podBudgets {
+		if len(budgets) > 1 {
+			blockingBudgets[pod] = budgets
+			continue
+		}
+		if podsPerBudget[budgets[0].Namespace+"/"+budgets[0].Name] > int(budgets[0].Status.DisruptionsAllowed) {
+			blockingBudgets[pod] = budgets
+		}
+	}
+
+	return
+}
+
+// getBudgetNames returns the names of pod disruption budgets as a readable list
+func getBudgetNames(budgets []policyv1.PodDisruptionBudget) string {
+	names := []string{}
+	for _, budget := range budgets {
+		names = append(names, budget.Name)
+	}
+	sort.Strings(names)
+	if len(names) == 1 {
+		return "budget " + names[0]
+	}
+
+	return "budgets " + strings.Join(names, ", ")
+}
+
+// CreateNodeEvent records an event about a node, shown when describing the node
+func (c *kubernetesClient) CreateNodeEvent(ctx context.Context, node v1.Node, eventType string, reason string, message string) (err error) {
+	now := metav1.NewTime(c.clock.Now())
+
+	_, err = c.kubeClientset.CoreV1().Events(metav1.NamespaceDefault).Create(ctx, &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: node.ObjectMeta.Name + ".",
+			Namespace:    metav1.NamespaceDefault,
+		},
+		InvolvedObject: v1.ObjectReference{
+			APIVersion: "v1",
+			Kind:       "Node",
+			Name:       node.ObjectMeta.Name,
+			UID:        node.ObjectMeta.UID,
+		},
+		Type:           eventType,
+		Reason:         reason,
+		Message:        message,
+		Source:         v1.EventSource{Component: "estafette-gke-preemptible-killer"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}, metav1.CreateOptions{})
+
+	return
+}
+
+// getPodsToDrain returns the pods to evict from a node per drain phase, and all of them
+func (c *kubernetesClient) getPodsToDrain(ctx context.Context, nodeName string, options DrainOptions) (phasePods [][]v1.Pod, pods []v1.Pod, err error) {
+	// Select all pods sitting on the node
+	podList, err := c.kubeClientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+		FieldSelector: fmt.Sprintf("spec.nodeName=%v", nodeName),
+	})
+	if err != nil {
 		return
 	}
 
-	log.Info().
-		Str("host", nodeName).
-		Msg("Done draining node")
+	// Filter out DaemonSet and static pods, and the pods excluded from draining
+	phasePods = options.selectPods(filterOutMirrorPods(filterOutPodByOwnerReferenceKind(filterOutPodByNode(podList.Items, nodeName), "DaemonSet")))
+	for _, podsOfPhase := range phasePods {
+		pods = append(pods, podsOfPhase...)
+	}
 
 	return
 }
 
-// DrainKubeDNSFromNode deletes any kube-dns pods running on the node
-func (c *kubernetesClient) DrainKubeDNSFromNode(ctx context.Context, nodeName string, drainTimeout int) (err error) {
-	// Select all pods sitting on the node except the one from kube-system
-	labelSelector := labels.Set{
-		"k8s-app": "kube-dns",
+// GetBlockedPods returns the pods to evict from a node whose pod disruption budgets can't currently allow their
+// eviction along with the other pods of the node, as namespace/name with the names of the budgets, so the kill can be
+// postponed before cordoning a node that can't be drained
+func (c *kubernetesClient) GetBlockedPods(ctx context.Context, nodeName string, options DrainOptions) (blockedPods []string, err error) {
+	_, pods, err := c.getPodsToDrain(ctx, nodeName, options)
+	if err != nil {
+		return
 	}
 
-	podList, err := c.kubeClientset.CoreV1().Pods("kube-system").List(ctx, metav1.ListOptions{
-		LabelSelector: labelSelector.String(),
-	})
+	budgets, err := c.getBlockingPodDisruptionBudgets(ctx, pods)
+	if err != nil {
+		return
+	}
 
+	for _, pod := range pods {
+		if podBudgets, ok := budgets[pod.Namespace+"/"+pod.Name]; ok {
+			blockedPods = append(blockedPods, fmt.Sprintf("%v/%v (%v)", pod.Namespace, pod.Name, getBudgetNames(podBudgets)))
+		}
+	}
+
+	return
+}
+
+// GetUnsafePods returns the pods to evict from a node with local storage and without a controller, with the outcome of
+// the drain when the actions for these pods keep it from starting, so the kill can be given up before cordoning the node
+func (c *kubernetesClient) GetUnsafePods(ctx context.Context, nodeName string, options DrainOptions) (result DrainResult, err error) {
+	_, pods, err := c.getPodsToDrain(ctx, nodeName, options)
 	if err != nil {
 		return
 	}
 
-	// Filter out pods running on other nodes
-	filteredPodList := filterOutPodByNode(podList.Items, nodeName)
+	return options.checkUnsafePods(pods), nil
+}
 
-	log.Info().
-		Str("host", nodeName).
-		Msgf("%d kube-dns pod(s) found", len(filteredPodList))
+// drainPods evicts the given pods from a node and waits until they're removed, or until the timeout; pods that can't
+// be evicted are reported and waited for like the others
+func (c *kubernetesClient) drainPods(ctx context.Context, nodeName string, pods []v1.Pod, settings evictionSettings, report *drainReport, timeout <-chan time.Time) (done bool) {
+	if len(pods) == 0 {
+		return true
+	}
 
-	stopEvicting := make(chan bool)
-	stopPolling := make(chan bool)
-	errCh := make(chan error)
-	defer func() {
-		if len(errCh) > 0 {
-			err = <-errCh
-		}
-	}()
+	podNames := map[string]bool{}
+	for _, pod := range pods {
+		podNames[pod.ObjectMeta.Namespace+"/"+pod.ObjectMeta.Name] = true
+	}
 
+	stop := make(chan bool)
+	defer close(stop)
+
+	// buffered so the goroutines can end after the drain returned
+	errCh := make(chan error, 1)
 	go func() {
-		if err := c.evictPods(ctx, filteredPodList, stopEvicting); err != nil {
-			errCh <- err
-		}
+		errCh <- c.evictPods(ctx, pods, settings, report, stop)
 	}()
 
-	doneDraining := make(chan bool)
+	doneDraining := make(chan bool, 1)
 	// Wait until all pods are deleted
 	go func() {
 		for {
-			sleepTime := ApplyJitter(10)
+			sleepTime := ApplyJitter(c.random, 10)
 			sleepDuration := time.Duration(sleepTime) * time.Second
-			podList, err := c.kubeClientset.CoreV1().Pods("kube-system").List(ctx, metav1.ListOptions{
-				LabelSelector: labelSelector.String(),
+			pendingPodList, err := c.kubeClientset.CoreV1().Pods("").List(ctx, metav1.ListOptions{
+				FieldSelector: fmt.Sprintf("spec.nodeName=%v", nodeName),
 			})
 
 			if err != nil {
-				log.Error().
+				log.Ctx(ctx).Error().
 					Err(err).
 					Str("host", nodeName).
-					Msgf("Error getting list of kube-dns pods, sleeping %ds", sleepTime)
-
-				time.Sleep(sleepDuration)
-				continue
-			}
+					Msgf("Error getting list of pods, sleeping %ds", sleepTime)
+			} else {
+				podsPending := 0
+				for _, pod := range filterOutPodByNode(pendingPodList.Items, nodeName) {
+					if podNames[pod.ObjectMeta.Namespace+"/"+pod.ObjectMeta.Name] {
+						podsPending++
+					}
+				}
 
-			// Filter out DaemonSet from the list of pods
-			filteredPendingPodList := filterOutPodByNode(podList.Items, nodeName)
-			podsPending := len(filteredPendingPodList)
+				if podsPending == 0 {
+					doneDraining <- true
+					return
+				}
 
-			if podsPending == 0 {
-				doneDraining <- true
-				return
+				log.Ctx(ctx).Info().
+					Str("host", nodeName).
+					Msgf("%d pod(s) pending deletion, sleeping %ds", podsPending, sleepTime)
 			}
 
-			log.Info().
-				Str("host", nodeName).
-				Msgf("%d pod(s) pending deletion, sleeping %ds", podsPending, sleepTime)
-
 			select {
-			case <-stopPolling:
+			case <-stop:
 				return
 			default:
-				time.Sleep(sleepDuration)
+				c.clock.Sleep(sleepDuration)
 			}
 		}
 	}()
 
-	select {
-	case <-doneDraining:
-		break
-	case <-time.After(time.Duration(drainTimeout) * time.Second):
-		log.Warn().
-			Str("host", nodeName).
-			Msg("Draining kube-dns node timeout reached")
-		close(stopPolling)
-		close(stopEvicting)
-		return
-	case <-ctx.Done():
-		close(stopPolling)
-		close(stopEvicting)
-		return
+	for {
+		select {
+		case <-doneDraining:
+			return true
+		case err := <-errCh:
+			// the pods that couldn't be evicted are in the report and stay on the node, so they're left for the drain
+			// timeout action like the pods whose evictions keep being refused
+			if err != nil {
+				log.Ctx(ctx).Warn().
+					Err(err).
+					Str("host", nodeName).
+					Msg("Pod(s) couldn't be evicted, leaving them for the drain timeout action")
+			}
+			// all pods are evicted, keep waiting for them to be gone
+			errCh = nil
+		case <-timeout:
+			return false
+		case <-ctx.Done():
+			return false
+		}
 	}
-
-	log.Info().
-		Str("host", nodeName).
-		Msg("Done draining kube-dns from node")
-
-	return
 }
 
-func (c *kubernetesClient) evictPods(ctx context.Context, pods []v1.Pod, stop <-chan bool) (lastErr error) {
+// evictPods evicts the pods in batches until they're all evicted or it's stopped, returning the errors of the pods
+// that couldn't be evicted
+func (c *kubernetesClient) evictPods(ctx context.Context, pods []v1.Pod, settings evictionSettings, report *drainReport, stop <-chan bool) error {
 	podsPerBatch := 10
-	numPodsLeft := len(pods)
-	podsProcessedSoFar := 0
-	errCh := make(chan error)
-	defer func() {
-		if len(errCh) > 0 {
-			thisErr := <-errCh
-			lastErr = fmt.Errorf("error evicting pods, last error was: %s", thisErr.Error())
-		}
-	}()
+	var errs []error
+	var mutex sync.Mutex
 
-	for numPodsLeft > 0 {
-		numPodsThisBatch := int(math.Min(float64(numPodsLeft), float64(podsPerBatch)))
-		podsThisBatch := pods[podsProcessedSoFar : podsProcessedSoFar+numPodsThisBatch]
-		stopChs := make([]chan bool, numPodsThisBatch)
-		for i := 0; i < len(stopChs); i++ {
-			stopChs[i] = make(chan bool)
+	for podsProcessedSoFar := 0; podsProcessedSoFar < len(pods); podsProcessedSoFar += podsPerBatch {
+		select {
+		case <-stop:
+			return utilerrors.NewAggregate(errs)
+		default:
 		}
+
+		podsThisBatch := pods[podsProcessedSoFar:int(math.Min(float64(len(pods)), float64(podsProcessedSoFar+podsPerBatch)))]
 		wg := &sync.WaitGroup{}
-		for i := 0; i < numPodsThisBatch; i++ {
+		for _, pod := range podsThisBatch {
 			wg.Add(1)
-			go func(i int) {
-				thisPod := podsThisBatch[i]
-				if err := c.evictPod(ctx, thisPod, stopChs[i]); err != nil {
-					log.Error().
+			go func(pod v1.Pod) {
+				defer wg.Done()
+				if err := c.evictPod(ctx, pod, settings, report, stop); err != nil {
+					log.Ctx(ctx).Error().
 						Err(err).
-						Msgf("failed to evict pod %s", thisPod.Name)
-					errCh <- err
-				}
-				wg.Done()
-			}(i)
-		}
+						Msgf("failed to evict pod %s", pod.Name)
+					report.setFailed(pod, err)
 
-		select {
-		case <-stop:
-			for _, ch := range stopChs {
-				close(ch)
-			}
-			return
-		default:
-			wg.Wait()
-			numPodsLeft -= numPodsThisBatch
-			podsProcessedSoFar += numPodsThisBatch
+					mutex.Lock()
+					errs = append(errs, fmt.Errorf("error evicting pod %s/%s: %w", pod.Namespace, pod.Name, err))
+					mutex.Unlock()
+				}
+			}(pod)
 		}
+		wg.Wait()
 	}
-	return
+
+	return utilerrors.NewAggregate(errs)
 }
 
-func (c *kubernetesClient) evictPod(ctx context.Context, pod v1.Pod, stop <-chan bool) error {
-	log.Info().
+// evictPod evicts a pod with the grace period it requests, shortened to the maximum grace period and the time left
+// until the deadline of the drain; refused evictions are retried with an exponential backoff, recording why they're
+// refused, until the retries run out
+func (c *kubernetesClient) evictPod(ctx context.Context, pod v1.Pod, settings evictionSettings, report *drainReport, stop <-chan bool) error {
+	log.Ctx(ctx).Info().
 		Str("host", pod.Spec.NodeName).
 		Msgf("Evicting pod %s", pod.Name)
-	eviction := &v1beta1.Eviction{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      pod.Name,
-			Namespace: pod.Namespace,
-		},
-	}
-	for {
-		err := c.kubeClientset.PolicyV1beta1().Evictions(eviction.Namespace).Evict(ctx, eviction)
+	for refusals := 1; ; refusals++ {
+		gracePeriod := getGracePeriod(pod, settings.maximumGracePeriod, settings.deadline.Sub(c.clock.Now()))
+		err := c.evict(ctx, pod, gracePeriod)
 		if err == nil {
-			log.Info().
-				Msgf("pod %s evicted", pod.Name)
-			break
+			if requested := getTerminationGracePeriod(pod); gracePeriod < requested {
+				log.Ctx(ctx).Warn().
+					Str("host", pod.Spec.NodeName).
+					Msgf("pod %s evicted with a grace period of %v instead of the requested %v", pod.Name, gracePeriod, requested)
+			} else {
+				log.Ctx(ctx).Info().
+					Msgf("pod %s evicted", pod.Name)
+			}
+			report.setEvicted(pod)
+			return nil
 		} else if errors.IsNotFound(err) {
-			log.Info().
+			log.Ctx(ctx).Info().
 				Msgf("pod %s already gone", pod.Name)
-			break
-		} else if errors.IsTooManyRequests(err) { //We get a 429 in the case of disruption budget related failures
-			log.Info().
-				Err(err).
-				Msgf("too many evictions while evicting %s, this may be due to pod disruption budget. trying again soon", pod.Name)
-			time.Sleep(5 * time.Second)
+			report.setGone(pod)
+			return nil
 		} else if errors.IsForbidden(err) && errors.HasStatusCause(err, v1.NamespaceTerminatingCause) {
-			log.Warn().
+			log.Ctx(ctx).Warn().
 				Msgf("cannot evict %s, namespace is being deleted", pod.Name)
 			//namespace is being deleted, finalizers should take care of deleting the pod
-			break
-		} else {
+			report.setGone(pod)
+			return nil
+		} else if !errors.IsTooManyRequests(err) {
 			return err
 		}
+
+		// we get a 429 when a pod disruption budget allows no disruption, or when the api server is overloaded
+		reason := getEvictionRefusalReason(err)
+		report.setBlocked(pod, reason)
+		if settings.retries > 0 && refusals > settings.retries {
+			log.Ctx(ctx).Warn().
+				Str("host", pod.Spec.NodeName).
+				Msgf("giving up evicting %s after %d refusals: %s", pod.Name, refusals, reason)
+			return nil
+		}
+
+		backoff := time.Duration(ApplyJitter(c.random, int(getEvictionBackoff(refusals).Seconds()))) * time.Second
+		if seconds, ok := errors.SuggestsClientDelay(err); ok && time.Duration(seconds)*time.Second > backoff {
+			backoff = time.Duration(seconds) * time.Second
+		}
+		log.Ctx(ctx).Info().
+			Str("host", pod.Spec.NodeName).
+			Msgf("eviction of %s refused (%s), trying again in %v", pod.Name, reason, backoff)
+
 		select {
+		case <-c.clock.After(backoff):
 		case <-stop:
 			return nil
-		default:
+		case <-ctx.Done():
+			return nil
 		}
 	}
-	return nil
+}
+
+// getEvictionRefusalReason returns why an eviction was refused, the message of the pod disruption budget blocking it
+// when there's one
+func getEvictionRefusalReason(err error) string {
+	if status, ok := err.(errors.APIStatus); ok && status.Status().Details != nil {
+		for _, cause := range status.Status().Details.Causes {
+			if cause.Type == policyv1.DisruptionBudgetCause {
+				return cause.Message
+			}
+		}
+	}
+
+	return err.Error()
+}
+
+// evict creates an eviction for the pod with the policy API version supported by the server
+func (c *kubernetesClient) evict(ctx context.Context, pod v1.Pod, gracePeriod time.Duration) error {
+	objectMeta := metav1.ObjectMeta{
+		Name:      pod.Name,
+		Namespace: pod.Namespace,
+	}
+	gracePeriodSeconds := int64(gracePeriod.Seconds())
+	deleteOptions := &metav1.DeleteOptions{
+		GracePeriodSeconds: &gracePeriodSeconds,
+	}
+
+	if c.evictionVersion == "v1" {
+		return c.kubeClientset.PolicyV1().Evictions(pod.Namespace).Evict(ctx, &policyv1.Eviction{ObjectMeta: objectMeta, DeleteOptions: deleteOptions})
+	}
+
+	return c.kubeClientset.PolicyV1beta1().Evictions(pod.Namespace).Evict(ctx, &v1beta1.Eviction{ObjectMeta: objectMeta, DeleteOptions: deleteOptions})
 }
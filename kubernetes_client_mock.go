@@ -6,107 +6,139 @@ package main
 
 import (
 	context "context"
+	reflect "reflect"
+
 	gomock "github.com/golang/mock/gomock"
 	v1 "k8s.io/api/core/v1"
-	reflect "reflect"
 )
 
-// MockKubernetesClient is a mock of KubernetesClient interface
+// MockKubernetesClient is a mock of KubernetesClient interface.
 type MockKubernetesClient struct {
 	ctrl     *gomock.Controller
 	recorder *MockKubernetesClientMockRecorder
 }
 
-// MockKubernetesClientMockRecorder is the mock recorder for MockKubernetesClient
+// MockKubernetesClientMockRecorder is the mock recorder for MockKubernetesClient.
 type MockKubernetesClientMockRecorder struct {
 	mock *MockKubernetesClient
 }
 
-// NewMockKubernetesClient creates a new mock instance
+// NewMockKubernetesClient creates a new mock instance.
 func NewMockKubernetesClient(ctrl *gomock.Controller) *MockKubernetesClient {
 	mock := &MockKubernetesClient{ctrl: ctrl}
 	mock.recorder = &MockKubernetesClientMockRecorder{mock}
 	return mock
 }
 
-// EXPECT returns an object that allows the caller to indicate expected use
+// EXPECT returns an object that allows the caller to indicate expected use.
 func (m *MockKubernetesClient) EXPECT() *MockKubernetesClientMockRecorder {
 	return m.recorder
 }
 
-// DrainNode mocks base method
-func (m *MockKubernetesClient) DrainNode(ctx context.Context, nodeName string, drainTimeout int) error {
+// CreateNodeEvent mocks base method.
+func (m *MockKubernetesClient) CreateNodeEvent(ctx context.Context, node v1.Node, eventType, reason, message string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DrainNode", ctx, nodeName, drainTimeout)
+	ret := m.ctrl.Call(m, "CreateNodeEvent", ctx, node, eventType, reason, message)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// DrainNode indicates an expected call of DrainNode
-func (mr *MockKubernetesClientMockRecorder) DrainNode(ctx, nodeName, drainTimeout interface{}) *gomock.Call {
+// CreateNodeEvent indicates an expected call of CreateNodeEvent.
+func (mr *MockKubernetesClientMockRecorder) CreateNodeEvent(ctx, node, eventType, reason, message interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DrainNode", reflect.TypeOf((*MockKubernetesClient)(nil).DrainNode), ctx, nodeName, drainTimeout)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateNodeEvent", reflect.TypeOf((*MockKubernetesClient)(nil).CreateNodeEvent), ctx, node, eventType, reason, message)
 }
 
-// DrainKubeDNSFromNode mocks base method
-func (m *MockKubernetesClient) DrainKubeDNSFromNode(ctx context.Context, nodeName string, drainTimeout int) error {
+// DeleteNode mocks base method.
+func (m *MockKubernetesClient) DeleteNode(ctx context.Context, nodeName string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DrainKubeDNSFromNode", ctx, nodeName, drainTimeout)
+	ret := m.ctrl.Call(m, "DeleteNode", ctx, nodeName)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
-// DrainKubeDNSFromNode indicates an expected call of DrainKubeDNSFromNode
-func (mr *MockKubernetesClientMockRecorder) DrainKubeDNSFromNode(ctx, nodeName, drainTimeout interface{}) *gomock.Call {
+// DeleteNode indicates an expected call of DeleteNode.
+func (mr *MockKubernetesClientMockRecorder) DeleteNode(ctx, nodeName interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DrainKubeDNSFromNode", reflect.TypeOf((*MockKubernetesClient)(nil).DrainKubeDNSFromNode), ctx, nodeName, drainTimeout)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteNode", reflect.TypeOf((*MockKubernetesClient)(nil).DeleteNode), ctx, nodeName)
 }
 
-// GetNode mocks base method
-func (m *MockKubernetesClient) GetNode(ctx context.Context, nodeName string) (*v1.Node, error) {
+// DrainNode mocks base method.
+func (m *MockKubernetesClient) DrainNode(ctx context.Context, nodeName string, drainTimeout int, options DrainOptions) (DrainResult, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetNode", ctx, nodeName)
-	ret0, _ := ret[0].(*v1.Node)
+	ret := m.ctrl.Call(m, "DrainNode", ctx, nodeName, drainTimeout, options)
+	ret0, _ := ret[0].(DrainResult)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetNode indicates an expected call of GetNode
-func (mr *MockKubernetesClientMockRecorder) GetNode(ctx, nodeName interface{}) *gomock.Call {
+// DrainNode indicates an expected call of DrainNode.
+func (mr *MockKubernetesClientMockRecorder) DrainNode(ctx, nodeName, drainTimeout, options interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNode", reflect.TypeOf((*MockKubernetesClient)(nil).GetNode), ctx, nodeName)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DrainNode", reflect.TypeOf((*MockKubernetesClient)(nil).DrainNode), ctx, nodeName, drainTimeout, options)
 }
 
-// DeleteNode mocks base method
-func (m *MockKubernetesClient) DeleteNode(ctx context.Context, nodeName string) error {
+// GetBlockedPods mocks base method.
+func (m *MockKubernetesClient) GetBlockedPods(ctx context.Context, nodeName string, options DrainOptions) ([]string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteNode", ctx, nodeName)
-	ret0, _ := ret[0].(error)
-	return ret0
+	ret := m.ctrl.Call(m, "GetBlockedPods", ctx, nodeName, options)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// DeleteNode indicates an expected call of DeleteNode
-func (mr *MockKubernetesClientMockRecorder) DeleteNode(ctx, nodeName interface{}) *gomock.Call {
+// GetBlockedPods indicates an expected call of GetBlockedPods.
+func (mr *MockKubernetesClientMockRecorder) GetBlockedPods(ctx, nodeName, options interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteNode", reflect.TypeOf((*MockKubernetesClient)(nil).DeleteNode), ctx, nodeName)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetBlockedPods", reflect.TypeOf((*MockKubernetesClient)(nil).GetBlockedPods), ctx, nodeName, options)
+}
+
+// GetKillPolicies mocks base method.
+func (m *MockKubernetesClient) GetKillPolicies(ctx context.Context) ([]PreemptibleKillPolicy, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetKillPolicies", ctx)
+	ret0, _ := ret[0].([]PreemptibleKillPolicy)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
 }
 
-// GetPreemptibleNodes mocks base method
-func (m *MockKubernetesClient) GetPreemptibleNodes(ctx context.Context, filters map[string]string) (*v1.NodeList, error) {
+// GetKillPolicies indicates an expected call of GetKillPolicies.
+func (mr *MockKubernetesClientMockRecorder) GetKillPolicies(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetKillPolicies", reflect.TypeOf((*MockKubernetesClient)(nil).GetKillPolicies), ctx)
+}
+
+// GetNode mocks base method.
+func (m *MockKubernetesClient) GetNode(ctx context.Context, nodeName string) (*v1.Node, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetPreemptibleNodes", ctx, filters)
+	ret := m.ctrl.Call(m, "GetNode", ctx, nodeName)
+	ret0, _ := ret[0].(*v1.Node)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetNode indicates an expected call of GetNode.
+func (mr *MockKubernetesClientMockRecorder) GetNode(ctx, nodeName interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetNode", reflect.TypeOf((*MockKubernetesClient)(nil).GetNode), ctx, nodeName)
+}
+
+// GetPreemptibleNodes mocks base method.
+func (m *MockKubernetesClient) GetPreemptibleNodes(ctx context.Context, filter NodeFilter) (*v1.NodeList, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetPreemptibleNodes", ctx, filter)
 	ret0, _ := ret[0].(*v1.NodeList)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetPreemptibleNodes indicates an expected call of GetPreemptibleNodes
-func (mr *MockKubernetesClientMockRecorder) GetPreemptibleNodes(ctx, filters interface{}) *gomock.Call {
+// GetPreemptibleNodes indicates an expected call of GetPreemptibleNodes.
+func (mr *MockKubernetesClientMockRecorder) GetPreemptibleNodes(ctx, filter interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPreemptibleNodes", reflect.TypeOf((*MockKubernetesClient)(nil).GetPreemptibleNodes), ctx, filters)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetPreemptibleNodes", reflect.TypeOf((*MockKubernetesClient)(nil).GetPreemptibleNodes), ctx, filter)
 }
 
-// GetProjectIdAndZoneFromNode mocks base method
+// GetProjectIdAndZoneFromNode mocks base method.
 func (m *MockKubernetesClient) GetProjectIdAndZoneFromNode(ctx context.Context, nodeName string) (string, string, error) {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "GetProjectIdAndZoneFromNode", ctx, nodeName)
@@ -116,13 +148,42 @@ func (m *MockKubernetesClient) GetProjectIdAndZoneFromNode(ctx context.Context,
 	return ret0, ret1, ret2
 }
 
-// GetProjectIdAndZoneFromNode indicates an expected call of GetProjectIdAndZoneFromNode
+// GetProjectIdAndZoneFromNode indicates an expected call of GetProjectIdAndZoneFromNode.
 func (mr *MockKubernetesClientMockRecorder) GetProjectIdAndZoneFromNode(ctx, nodeName interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetProjectIdAndZoneFromNode", reflect.TypeOf((*MockKubernetesClient)(nil).GetProjectIdAndZoneFromNode), ctx, nodeName)
 }
 
-// SetNodeAnnotation mocks base method
+// GetUnsafePods mocks base method.
+func (m *MockKubernetesClient) GetUnsafePods(ctx context.Context, nodeName string, options DrainOptions) (DrainResult, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetUnsafePods", ctx, nodeName, options)
+	ret0, _ := ret[0].(DrainResult)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUnsafePods indicates an expected call of GetUnsafePods.
+func (mr *MockKubernetesClientMockRecorder) GetUnsafePods(ctx, nodeName, options interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUnsafePods", reflect.TypeOf((*MockKubernetesClient)(nil).GetUnsafePods), ctx, nodeName, options)
+}
+
+// RemoveNodeAnnotation mocks base method.
+func (m *MockKubernetesClient) RemoveNodeAnnotation(ctx context.Context, nodeName, key string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "RemoveNodeAnnotation", ctx, nodeName, key)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// RemoveNodeAnnotation indicates an expected call of RemoveNodeAnnotation.
+func (mr *MockKubernetesClientMockRecorder) RemoveNodeAnnotation(ctx, nodeName, key interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveNodeAnnotation", reflect.TypeOf((*MockKubernetesClient)(nil).RemoveNodeAnnotation), ctx, nodeName, key)
+}
+
+// SetNodeAnnotation mocks base method.
 func (m *MockKubernetesClient) SetNodeAnnotation(ctx context.Context, nodeName, key, value string) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "SetNodeAnnotation", ctx, nodeName, key, value)
@@ -130,13 +191,13 @@ func (m *MockKubernetesClient) SetNodeAnnotation(ctx context.Context, nodeName,
 	return ret0
 }
 
-// SetNodeAnnotation indicates an expected call of SetNodeAnnotation
+// SetNodeAnnotation indicates an expected call of SetNodeAnnotation.
 func (mr *MockKubernetesClientMockRecorder) SetNodeAnnotation(ctx, nodeName, key, value interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetNodeAnnotation", reflect.TypeOf((*MockKubernetesClient)(nil).SetNodeAnnotation), ctx, nodeName, key, value)
 }
 
-// SetUnschedulableState mocks base method
+// SetUnschedulableState mocks base method.
 func (m *MockKubernetesClient) SetUnschedulableState(ctx context.Context, nodeName string, unschedulable bool) error {
 	m.ctrl.T.Helper()
 	ret := m.ctrl.Call(m, "SetUnschedulableState", ctx, nodeName, unschedulable)
@@ -144,8 +205,22 @@ func (m *MockKubernetesClient) SetUnschedulableState(ctx context.Context, nodeNa
 	return ret0
 }
 
-// SetUnschedulableState indicates an expected call of SetUnschedulableState
+// SetUnschedulableState indicates an expected call of SetUnschedulableState.
 func (mr *MockKubernetesClientMockRecorder) SetUnschedulableState(ctx, nodeName, unschedulable interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetUnschedulableState", reflect.TypeOf((*MockKubernetesClient)(nil).SetUnschedulableState), ctx, nodeName, unschedulable)
 }
+
+// UpdateKillPolicyStatus mocks base method.
+func (m *MockKubernetesClient) UpdateKillPolicyStatus(ctx context.Context, policy PreemptibleKillPolicy) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UpdateKillPolicyStatus", ctx, policy)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UpdateKillPolicyStatus indicates an expected call of UpdateKillPolicyStatus.
+func (mr *MockKubernetesClientMockRecorder) UpdateKillPolicyStatus(ctx, policy interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateKillPolicyStatus", reflect.TypeOf((*MockKubernetesClient)(nil).UpdateKillPolicyStatus), ctx, policy)
+}
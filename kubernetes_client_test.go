@@ -1,10 +1,27 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
 	"testing"
+	"time"
 
+	"github.com/rs/zerolog"
 	v1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
 )
 
 func TestFilterOutPodByOwnerReferenceKind(t *testing.T) {
@@ -43,3 +60,633 @@ func TestFilterOutPodByOwnerReferenceKind(t *testing.T) {
 		t.Errorf("Expect first item name to be 'node-2', instead got %s", filteredPodList[0].ObjectMeta.Name)
 	}
 }
+
+func TestDrainNode_Timeout(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctx := context.Background()
+
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod-1",
+			Namespace: "default",
+			OwnerReferences: []metav1.OwnerReference{
+				{
+					Kind: "ReplicaSet",
+					Name: "replica-set",
+				},
+			},
+		},
+		Spec: v1.PodSpec{
+			NodeName: "node-1",
+		},
+	}
+
+	kubeClientset := fake.NewSimpleClientset(pod)
+	// accept evictions without ever removing the pod, so the drain can only end by timing out
+	kubeClientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return action.GetSubresource() == "eviction", nil, nil
+	})
+
+	start := time.Date(2017, 11, 11, 12, 00, 00, 0, time.UTC)
+	clock := newFakeClock(start)
+
+	client, _ := NewKubernetesClient(kubeClientset, nil, clock, NewRandom(0))
+
+	result, err := client.DrainNode(ctx, "node-1", 300, DrainOptions{})
+
+	if err != nil {
+		t.Errorf("Expect drain to time out without error, instead got %v", err)
+	}
+
+	if result.Outcome != DrainOutcomeProceeded || len(result.RemainingPods) != 1 || result.RemainingPods[0] != "default/pod-1" {
+		t.Errorf("Expect drain to proceed with pod default/pod-1 remaining, instead got %v", result)
+	}
+
+	if clock.Now().Before(start.Add(300 * time.Second)) {
+		t.Errorf("Expect drain to wait for the drain timeout, instead it returned after %s", clock.Now().Sub(start))
+	}
+}
+
+func TestNewKubeClientConfig(t *testing.T) {
+	kubeConfigPath := filepath.Join(t.TempDir(), "config")
+	kubeConfig := `apiVersion: v1
+kind: Config
+current-context: first
+clusters:
+- name: first
+  cluster:
+    server: https://first.example.com
+- name: second
+  cluster:
+    server: https://second.example.com
+contexts:
+- name: first
+  context:
+    cluster: first
+    user: user
+- name: second
+  context:
+    cluster: second
+    user: user
+users:
+- name: user
+  user:
+    token: secret
+`
+	if err := os.WriteFile(kubeConfigPath, []byte(kubeConfig), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := NewKubeClientConfig(kubeConfigPath, "")
+	if err != nil {
+		t.Fatalf("Expect kube config to load, instead got %v", err)
+	}
+	if config.Host != "https://first.example.com" {
+		t.Errorf("Expect host of current context https://first.example.com, instead got %s", config.Host)
+	}
+
+	config, err = NewKubeClientConfig(kubeConfigPath, "second")
+	if err != nil {
+		t.Fatalf("Expect kube config to load, instead got %v", err)
+	}
+	if config.Host != "https://second.example.com" {
+		t.Errorf("Expect host of selected context https://second.example.com, instead got %s", config.Host)
+	}
+
+	_, err = NewKubeClientConfig(kubeConfigPath, "third")
+	if err == nil {
+		t.Errorf("Expect error for unknown context")
+	}
+}
+
+func TestKillPolicies(t *testing.T) {
+	ctx := context.Background()
+
+	killPolicy := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "estafette.io/v1",
+		"kind":       "PreemptibleKillPolicy",
+		"metadata": map[string]interface{}{
+			"name": "batch",
+		},
+		"spec": map[string]interface{}{
+			"nodePool":        "batch",
+			"maximumLifetime": "6h",
+			"drainTimeout":    int64(60),
+		},
+	}}
+
+	dynamicClient := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), map[schema.GroupVersionResource]string{
+		killPolicyResource: "PreemptibleKillPolicyList",
+	}, killPolicy)
+
+	client, _ := NewKubernetesClient(fake.NewSimpleClientset(), dynamicClient, newFakeClock(time.Now()), NewRandom(0))
+
+	policies, err := client.GetKillPolicies(ctx)
+	if err != nil {
+		t.Fatalf("Expect listing kill policies to succeed, instead got %v", err)
+	}
+
+	if len(policies) != 1 || policies[0].Spec.NodePool != "batch" || policies[0].Spec.MaximumLifetime.Duration != 6*time.Hour || *policies[0].Spec.DrainTimeout != 60 {
+		t.Fatalf("Expect kill policy batch with its spec, instead got %v", policies)
+	}
+
+	policies[0].Status.NodesManaged = 3
+	err = client.UpdateKillPolicyStatus(ctx, policies[0])
+	if err != nil {
+		t.Fatalf("Expect updating kill policy status to succeed, instead got %v", err)
+	}
+
+	updated, _ := dynamicClient.Resource(killPolicyResource).Get(ctx, "batch", metav1.GetOptions{})
+	nodesManaged, _, _ := unstructured.NestedInt64(updated.Object, "status", "nodesManaged")
+	if nodesManaged != 3 {
+		t.Errorf("Expect 3 nodes managed in the status, instead got %d", nodesManaged)
+	}
+}
+
+func TestGetPreemptibleNodes(t *testing.T) {
+	ctx := context.Background()
+
+	newNode := func(name string, nodeLabels map[string]string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: nodeLabels}}
+	}
+
+	kubeClientset := fake.NewSimpleClientset(
+		newNode("node-1", map[string]string{"cloud.google.com/gke-preemptible": "true", "cloud.google.com/gke-nodepool": "default"}),
+		newNode("node-2", map[string]string{"cloud.google.com/gke-preemptible": "true", "cloud.google.com/gke-nodepool": "gpu"}),
+		newNode("node-3", map[string]string{"cloud.google.com/gke-nodepool": "default"}),
+	)
+
+	client, _ := NewKubernetesClient(kubeClientset, nil, newFakeClock(time.Now()), NewRandom(0))
+
+	filter, _ := parseFilters("cloud.google.com/gke-nodepool notin (gpu)")
+	nodes, err := client.GetPreemptibleNodes(ctx, filter)
+	if err != nil {
+		t.Fatalf("Expect listing nodes to succeed, instead got %v", err)
+	}
+
+	if len(nodes.Items) != 1 || nodes.Items[0].ObjectMeta.Name != "node-1" {
+		t.Errorf("Expect only preemptible node-1 outside the gpu pool, instead got %v", nodes.Items)
+	}
+}
+
+func TestRemoveNodeAnnotation(t *testing.T) {
+	ctx := context.Background()
+
+	kubeClientset := fake.NewSimpleClientset(&v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+			Annotations: map[string]string{
+				"cluster-autoscaler.kubernetes.io/scale-down-disabled": "true",
+				"estafette.io/gke-preemptible-killer-state":            "2017-11-12T11:00:00Z",
+			},
+		},
+	})
+
+	client, _ := NewKubernetesClient(kubeClientset, nil, newFakeClock(time.Now()), NewRandom(0))
+
+	err := client.RemoveNodeAnnotation(ctx, "node-1", "cluster-autoscaler.kubernetes.io/scale-down-disabled")
+	if err != nil {
+		t.Fatalf("Expect removing annotation to succeed, instead got %v", err)
+	}
+
+	node, _ := client.GetNode(ctx, "node-1")
+	if len(node.ObjectMeta.Annotations) != 1 || node.ObjectMeta.Annotations["estafette.io/gke-preemptible-killer-state"] == "" {
+		t.Errorf("Expect only the scale down annotation to be removed, instead got %v", node.ObjectMeta.Annotations)
+	}
+}
+
+func TestEvict(t *testing.T) {
+	ctx := context.Background()
+
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod-1",
+			Namespace: "default",
+		},
+	}
+
+	versions := map[string][]metav1.APIResource{
+		"v1":      {{Name: "pods"}, {Name: "pods/eviction", Group: "policy", Version: "v1", Kind: "Eviction"}},
+		"v1beta1": {{Name: "pods"}, {Name: "pods/eviction", Group: "policy", Version: "v1beta1", Kind: "Eviction"}},
+	}
+
+	for version, resources := range versions {
+		kubeClientset := fake.NewSimpleClientset()
+		kubeClientset.Resources = []*metav1.APIResourceList{{GroupVersion: "v1", APIResources: resources}}
+
+		evictedWith := ""
+		var gracePeriodSeconds *int64
+		kubeClientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			switch eviction := action.(k8stesting.CreateAction).GetObject().(type) {
+			case *policyv1.Eviction:
+				evictedWith = "v1"
+				gracePeriodSeconds = eviction.DeleteOptions.GracePeriodSeconds
+			case *v1beta1.Eviction:
+				evictedWith = "v1beta1"
+				gracePeriodSeconds = eviction.DeleteOptions.GracePeriodSeconds
+			}
+			return true, nil, nil
+		})
+
+		client, err := NewKubernetesClient(kubeClientset, nil, newFakeClock(time.Now()), NewRandom(0))
+		if err != nil {
+			t.Fatalf("Expect client to be created, instead got %v", err)
+		}
+
+		err = client.(*kubernetesClient).evict(ctx, pod, 45*time.Second)
+		if err != nil {
+			t.Errorf("Expect eviction to succeed, instead got %v", err)
+		}
+		if evictedWith != version {
+			t.Errorf("Expect eviction with policy/%v, instead got policy/%v", version, evictedWith)
+		}
+		if gracePeriodSeconds == nil || *gracePeriodSeconds != 45 {
+			t.Errorf("Expect eviction with a grace period of 45 seconds, instead got %v", gracePeriodSeconds)
+		}
+	}
+}
+
+func TestEvictPod_Refused(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctx := context.Background()
+
+	pod := v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "pod-1",
+			Namespace: "default",
+		},
+	}
+
+	start := time.Date(2017, 11, 11, 12, 00, 00, 0, time.UTC)
+	clock := newFakeClock(start)
+
+	kubeClientset := fake.NewSimpleClientset()
+	var attempts []time.Time
+	kubeClientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		attempts = append(attempts, clock.Now())
+		return true, nil, &errors.StatusError{ErrStatus: metav1.Status{
+			Status: metav1.StatusFailure,
+			Code:   http.StatusTooManyRequests,
+			Reason: metav1.StatusReasonTooManyRequests,
+			Details: &metav1.StatusDetails{
+				RetryAfterSeconds: 90,
+				Causes: []metav1.StatusCause{{
+					Type:    policyv1.DisruptionBudgetCause,
+					Message: "The disruption budget web needs 2 healthy pods and has 2 currently",
+				}},
+			},
+		}}
+	})
+
+	client, _ := NewKubernetesClient(kubeClientset, nil, clock, NewRandom(0))
+
+	settings := evictionSettings{deadline: start.Add(time.Hour), maximumGracePeriod: time.Minute, retries: 2}
+	report := newDrainReport()
+	done := make(chan error)
+	go func() {
+		done <- client.(*kubernetesClient).evictPod(ctx, pod, settings, report, make(chan bool))
+	}()
+
+	var err error
+	for waiting := true; waiting; {
+		select {
+		case err = <-done:
+			waiting = false
+		default:
+			clock.Sleep(time.Second)
+		}
+	}
+
+	if err != nil {
+		t.Errorf("Expect eviction to give up without error, instead got %v", err)
+	}
+	if len(attempts) != 3 {
+		t.Fatalf("Expect 3 eviction attempts, instead got %d", len(attempts))
+	}
+	for i := 1; i < len(attempts); i++ {
+		if delay := attempts[i].Sub(attempts[i-1]); delay < 90*time.Second {
+			t.Errorf("Expect retry to wait for the 90s the server asks for, instead waited %v", delay)
+		}
+	}
+	result := DrainResult{RemainingPods: []string{"default/pod-1"}}
+	report.apply(&result)
+	if result.BlockedPods["default/pod-1"] != "The disruption budget web needs 2 healthy pods and has 2 currently" {
+		t.Errorf("Expect pod to be blocked by the disruption budget, instead got %v", result.BlockedPods)
+	}
+}
+
+func TestGetEvictionVersion_NotDiscovered(t *testing.T) {
+	version, err := getEvictionVersion(fake.NewSimpleClientset().Discovery())
+	if err != nil {
+		t.Fatalf("Expect no error, instead got %v", err)
+	}
+	if version != "v1beta1" {
+		t.Errorf("Expect fallback to v1beta1, instead got %v", version)
+	}
+}
+
+func TestDrainNode_SystemNamespacesLast(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctx := context.Background()
+
+	newPod := func(namespace, name string, labels map[string]string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       namespace,
+				Labels:          labels,
+				OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: name}},
+			},
+			Spec: v1.PodSpec{
+				NodeName: "node-1",
+			},
+		}
+	}
+
+	kubeClientset := fake.NewSimpleClientset(
+		newPod("kube-system", "metrics-server", nil),
+		newPod("default", "web", nil),
+		newPod("default", "cache", map[string]string{"drain": "skip"}),
+		newPod("monitoring", "prometheus", nil),
+	)
+
+	// evict pods by removing them right away
+	var evicted []string
+	kubeClientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		eviction := action.(k8stesting.CreateAction).GetObject().(metav1.Object)
+		evicted = append(evicted, eviction.GetNamespace()+"/"+eviction.GetName())
+		return true, nil, kubeClientset.Tracker().Delete(schema.GroupVersionResource{Version: "v1", Resource: "pods"}, eviction.GetNamespace(), eviction.GetName())
+	})
+
+	client, _ := NewKubernetesClient(kubeClientset, nil, newFakeClock(time.Now()), NewRandom(0))
+
+	excludeNamespaces := "monitoring"
+	excludePods := "drain=skip"
+	systemNamespaces := "kube-system"
+	options, err := DrainConfig{
+		DrainExcludeNamespaces: &excludeNamespaces,
+		DrainExcludePods:       &excludePods,
+		DrainSystemNamespaces:  &systemNamespaces,
+	}.getDrainOptions()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := client.DrainNode(ctx, "node-1", 300, options)
+	if err != nil {
+		t.Errorf("Expect drain to succeed, instead got %v", err)
+	}
+
+	expected := []string{"default/web", "kube-system/metrics-server"}
+	if len(evicted) != len(expected) || evicted[0] != expected[0] || evicted[1] != expected[1] {
+		t.Errorf("Expect evictions %v, instead got %v", expected, evicted)
+	}
+	if result.Outcome != DrainOutcomeDrained || len(result.EvictedPods) != 2 {
+		t.Errorf("Expect drain to report 2 evicted pods, instead got %v: %v", result.Outcome, result)
+	}
+}
+
+func TestDrainNode_EvictionFailed(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctx := context.Background()
+
+	newPod := func(name string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            name,
+				Namespace:       "default",
+				OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "replica-set"}},
+			},
+			Spec: v1.PodSpec{
+				NodeName: "node-1",
+			},
+		}
+	}
+
+	// the eviction of pod-1 fails, the eviction of pod-2 is accepted but the pod never leaves
+	kubeClientset := fake.NewSimpleClientset(newPod("pod-1"), newPod("pod-2"))
+	kubeClientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "eviction" {
+			return false, nil, nil
+		}
+		if action.(k8stesting.CreateAction).GetObject().(metav1.Object).GetName() == "pod-1" {
+			return true, nil, errors.NewInternalError(fmt.Errorf("etcd unavailable"))
+		}
+		return true, nil, nil
+	})
+
+	start := time.Date(2017, 11, 11, 12, 00, 00, 0, time.UTC)
+	clock := newFakeClock(start)
+
+	client, _ := NewKubernetesClient(kubeClientset, nil, clock, NewRandom(0))
+
+	result, err := client.DrainNode(ctx, "node-1", 300, DrainOptions{})
+
+	if err != nil {
+		t.Errorf("Expect the failed eviction to be left for the timeout action, instead got %v", err)
+	}
+	if result.Outcome != DrainOutcomeProceeded || result.FailedPods["default/pod-1"] == "" {
+		t.Errorf("Expect drain to proceed with pod default/pod-1 reported as failed, instead got %v: %v", result.Outcome, result.FailedPods)
+	}
+	if fmt.Sprint(result.EvictedPods) != "[default/pod-2]" {
+		t.Errorf("Expect pod default/pod-2 to be evicted despite the failure, instead got %v", result.EvictedPods)
+	}
+	if clock.Now().Before(start.Add(300 * time.Second)) {
+		t.Errorf("Expect drain to wait for the drain timeout, instead it returned after %s", clock.Now().Sub(start))
+	}
+}
+
+func TestDrainNode_TimeoutAction(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctx := context.Background()
+
+	cases := []struct {
+		options         DrainOptions
+		expectedOutcome DrainOutcome
+		expectedDeleted bool
+	}{
+		{DrainOptions{TimeoutAction: "proceed"}, DrainOutcomeProceeded, false},
+		{DrainOptions{TimeoutAction: "abort"}, DrainOutcomeAborted, false},
+		{DrainOptions{TimeoutAction: "delete"}, DrainOutcomeAborted, false},
+		{DrainOptions{TimeoutAction: "delete", DeleteIgnorePDBs: true}, DrainOutcomeDeleted, true},
+	}
+
+	for _, c := range cases {
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            "pod-1",
+				Namespace:       "default",
+				Labels:          map[string]string{"app": "web"},
+				OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "replica-set"}},
+			},
+			Spec: v1.PodSpec{
+				NodeName: "node-1",
+			},
+		}
+		budget := &policyv1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "web",
+				Namespace: "default",
+			},
+			Spec: policyv1.PodDisruptionBudgetSpec{
+				Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "web"}},
+			},
+			Status: policyv1.PodDisruptionBudgetStatus{
+				DisruptionsAllowed: 0,
+			},
+		}
+
+		kubeClientset := fake.NewSimpleClientset(pod, budget)
+		kubeClientset.Resources = []*metav1.APIResourceList{{GroupVersion: "v1", APIResources: []metav1.APIResource{{Name: "pods/eviction", Group: "policy", Version: "v1", Kind: "Eviction"}}}}
+		// the disruption budget blocks all evictions
+		kubeClientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+			if action.GetSubresource() != "eviction" {
+				return false, nil, nil
+			}
+			return true, nil, errors.NewTooManyRequests("Cannot evict pod as it would violate the pod's disruption budget.", 10)
+		})
+
+		client, _ := NewKubernetesClient(kubeClientset, nil, newFakeClock(time.Now()), NewRandom(0))
+
+		result, err := client.DrainNode(ctx, "node-1", 60, c.options)
+		if err != nil {
+			t.Fatalf("Expect drain to time out without error for action %v, instead got %v", c.options.TimeoutAction, err)
+		}
+
+		if result.Outcome != c.expectedOutcome {
+			t.Errorf("Expect outcome %v for action %v, instead got %v", c.expectedOutcome, c.options.TimeoutAction, result.Outcome)
+		}
+
+		_, err = kubeClientset.CoreV1().Pods("default").Get(ctx, "pod-1", metav1.GetOptions{})
+		if deleted := errors.IsNotFound(err); deleted != c.expectedDeleted {
+			t.Errorf("Expect pod deleted to be %v for action %v with ignoring budgets %v, instead got %v", c.expectedDeleted, c.options.TimeoutAction, c.options.DeleteIgnorePDBs, deleted)
+		}
+	}
+}
+
+func TestDrainNode_Refused(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctx := context.Background()
+
+	kubeClientset := fake.NewSimpleClientset(
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web"}}},
+			Spec:       v1.PodSpec{NodeName: "node-1"},
+		},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "default"},
+			Spec:       v1.PodSpec{NodeName: "node-1"},
+		},
+	)
+
+	evictions := 0
+	kubeClientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() == "eviction" {
+			evictions++
+		}
+		return false, nil, nil
+	})
+
+	client, _ := NewKubernetesClient(kubeClientset, nil, newFakeClock(time.Now()), NewRandom(0))
+
+	result, err := client.DrainNode(ctx, "node-1", 300, DrainOptions{LocalStoragePods: "proceed", BarePods: "refuse"})
+
+	if err != nil {
+		t.Errorf("Expect refused drain without error, instead got %v", err)
+	}
+	if result.Outcome != DrainOutcomeRefused || len(result.BarePods) != 2 {
+		t.Errorf("Expect drain to be refused for 2 bare pods, instead got %v", result)
+	}
+	if evictions != 0 {
+		t.Errorf("Expect no evictions, instead got %d", evictions)
+	}
+}
+
+func TestGetUnsafePods(t *testing.T) {
+	ctx := context.Background()
+
+	controller := true
+
+	kubeClientset := fake.NewSimpleClientset(
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default", OwnerReferences: []metav1.OwnerReference{{Kind: "ReplicaSet", Name: "web", Controller: &controller}}},
+			Spec:       v1.PodSpec{NodeName: "node-1"},
+		},
+		&v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "debug", Namespace: "default"},
+			Spec:       v1.PodSpec{NodeName: "node-1"},
+		},
+	)
+
+	client, _ := NewKubernetesClient(kubeClientset, nil, newFakeClock(time.Now()), NewRandom(0))
+
+	result, err := client.GetUnsafePods(ctx, "node-1", DrainOptions{LocalStoragePods: "proceed", BarePods: "refuse"})
+
+	if err != nil {
+		t.Fatalf("Expect checking pods to succeed, instead got %v", err)
+	}
+	if result.Outcome != DrainOutcomeRefused || fmt.Sprint(result.BarePods) != "[default/debug]" {
+		t.Errorf("Expect drain to be refused for bare pod default/debug, instead got %v with bare pods %v", result.Outcome, result.BarePods)
+	}
+}
+
+func TestGetBlockedPods(t *testing.T) {
+	ctx := context.Background()
+
+	newPod := func(name string, app string) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", Labels: map[string]string{"app": app}},
+			Spec:       v1.PodSpec{NodeName: "node-1"},
+		}
+	}
+	newBudget := func(app string, disruptionsAllowed int32) *v1beta1.PodDisruptionBudget {
+		return &v1beta1.PodDisruptionBudget{
+			ObjectMeta: metav1.ObjectMeta{Name: app, Namespace: "default"},
+			Spec:       v1beta1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": app}}},
+			Status:     v1beta1.PodDisruptionBudgetStatus{DisruptionsAllowed: disruptionsAllowed},
+		}
+	}
+
+	cache := newPod("cache-1", "cache")
+	cache.ObjectMeta.Labels["tier"] = "backend"
+	backend := newBudget("backend", 5)
+	backend.Spec.Selector = &metav1.LabelSelector{MatchLabels: map[string]string{"tier": "backend"}}
+
+	// an older cluster, with pod disruption budgets in policy/v1beta1; the web budget allows a single disruption of
+	// its two pods on the node, and the eviction of a pod covered by two budgets is refused by the API
+	kubeClientset := fake.NewSimpleClientset(
+		newPod("web-1", "web"),
+		newPod("web-2", "web"),
+		newPod("api-1", "api"),
+		newPod("database-1", "database"),
+		newPod("worker-1", "worker"),
+		cache,
+		newBudget("web", 1),
+		newBudget("api", 1),
+		newBudget("database", 0),
+		newBudget("cache", 5),
+		backend,
+	)
+
+	client, _ := NewKubernetesClient(kubeClientset, nil, newFakeClock(time.Now()), NewRandom(0))
+
+	blockedPods, err := client.GetBlockedPods(ctx, "node-1", DrainOptions{})
+
+	if err != nil {
+		t.Fatalf("Expect checking budgets to succeed, instead got %v", err)
+	}
+	sort.Strings(blockedPods)
+	expected := "[default/cache-1 (budgets backend, cache) default/database-1 (budget database) default/web-1 (budget web) default/web-2 (budget web)]"
+	if fmt.Sprint(blockedPods) != expected {
+		t.Errorf("Expect blocked pods %v, instead got %v", expected, blockedPods)
+	}
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"math"
-	"math/rand"
+	"os"
 	"runtime"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -13,17 +15,44 @@ import (
 	"github.com/alecthomas/kingpin"
 	foundation "github.com/estafette/estafette-foundation"
 	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 
 	v1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	k8sruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 )
 
 const (
 	// annotationGKEPreemptibleKillerState is the key of the annotation to use to store the expiry datetime
 	annotationGKEPreemptibleKillerState string = "estafette.io/gke-preemptible-killer-state"
+
+	// annotationGKEPreemptibleKillerScaleDownDisabledUntil is the key of the annotation to use to store until when the
+	// killer disabled scale down of a new node by the cluster autoscaler
+	annotationGKEPreemptibleKillerScaleDownDisabledUntil string = "estafette.io/gke-preemptible-killer-scale-down-disabled-until"
+
+	// annotationGKEPreemptibleKillerDrainOrder is the key of the pod annotation setting the order in which pods are
+	// evicted, pods with a lower order leave first
+	annotationGKEPreemptibleKillerDrainOrder string = "estafette.io/gke-preemptible-killer-drain-order"
+
+	// annotationScaleDownDisabled is the key of the annotation that stops the cluster autoscaler from removing a node
+	annotationScaleDownDisabled string = "cluster-autoscaler.kubernetes.io/scale-down-disabled"
+
+	// taintToBeDeletedByClusterAutoscaler is the key of the taint the cluster autoscaler sets on nodes it's removing
+	taintToBeDeletedByClusterAutoscaler string = "ToBeDeletedByClusterAutoscaler"
+
+	// labelGKENodePool is the key of the label GKE uses to store the name of the node pool of a node
+	labelGKENodePool string = "cloud.google.com/gke-nodepool"
+
+	// postponeDelay is the time the expiry of a node is pushed forward when its kill is postponed
+	postponeDelay = 30 * time.Minute
+
+	// spreadPlanningStep is the resolution at which candidate expiry dates are evaluated when spreading kills
+	spreadPlanningStep = 5 * time.Minute
 )
 
 // GKEPreemptibleKillerState represents the state of gke-preemptible-killer
@@ -32,17 +61,73 @@ type GKEPreemptibleKillerState struct {
 }
 
 var (
+	// commands
+	runCommand      = kingpin.Command("run", "Kill the preemptible nodes of the cluster before GCloud preempts them.").Default()
+	planCommand     = kingpin.Command("plan", "Print when the preemptible nodes of the cluster are scheduled to be killed.")
+	simulateCommand = kingpin.Command("simulate", "Simulate the expiry of synthetic nodes to preview the effect of the whitelist, blacklist and lifetime settings.")
+
 	// flags
+	configFile = kingpin.Flag("config-file", "Path to a yaml or json configuration file listing the clusters to kill preemptible nodes in.").
+			Envar("CONFIG_FILE").
+			String()
+	annotationFilters = kingpin.Flag("annotation-filters", "Annotation selector of the nodes to process like `key1=value1, !key2`.").
+				Envar("ANNOTATION_FILTERS").
+				Default("").
+				String()
+	barePods = kingpin.Flag("bare-pods", "What to do when pods without a controller, which are lost when evicted, are on a node to kill: `proceed`, `postpone` the kill or `refuse` to kill the node.").
+			Envar("BARE_PODS").
+			Default("proceed").
+			Enum("proceed", "postpone", "refuse")
 	blacklist = kingpin.Flag("blacklist-hours", "List of UTC time intervals in the form of `09:00 - 12:00, 13:00 - 18:00` in which deletion is NOT allowed").
 			Envar("BLACKLIST_HOURS").
 			Default("").
 			Short('b').
 			String()
+	conditionFilters = kingpin.Flag("condition-filters", "Condition selector of the nodes to process on the status of their conditions like `Ready=True, DiskPressure!=True`.").
+				Envar("CONDITION_FILTERS").
+				Default("").
+				String()
+	deleteGracePeriod = kingpin.Flag("delete-grace-period", "Grace period of the pods deleted when the drain timeout action is delete, 0 deletes them right away.").
+				Envar("DELETE_GRACE_PERIOD").
+				Default("0s").
+				Duration()
+	deleteIgnorePDBs = kingpin.Flag("delete-ignore-pdbs", "Delete the pods left after the drain timeout even when their PodDisruptionBudget allows no disruption, instead of aborting the kill.").
+				Envar("DELETE_IGNORE_PDBS").
+				Default("false").
+				Bool()
+	drainEvictionRetries = kingpin.Flag("drain-eviction-retries", "Max number of times the eviction of a pod is retried while refused, backing off exponentially, 0 for no limit.").
+				Envar("DRAIN_EVICTION_RETRIES").
+				Default("20").
+				Int()
+	drainExcludeNamespaces = kingpin.Flag("drain-exclude-namespaces", "Comma separated list of namespaces whose pods are left on the nodes when draining them.").
+				Envar("DRAIN_EXCLUDE_NAMESPACES").
+				Default("").
+				String()
+	drainExcludePods = kingpin.Flag("drain-exclude-pods", "Label selector of the pods left on the nodes when draining them like `app in (value1, value2), !key`.").
+				Envar("DRAIN_EXCLUDE_PODS").
+				Default("").
+				String()
+	drainIncludePods = kingpin.Flag("drain-include-pods", "Label selector of the pods evicted when draining nodes even when their namespace or labels are excluded.").
+				Envar("DRAIN_INCLUDE_PODS").
+				Default("").
+				String()
+	drainSystemNamespaces = kingpin.Flag("drain-system-namespaces", "Comma separated list of namespaces whose pods are evicted after the pods of all other namespaces.").
+				Envar("DRAIN_SYSTEM_NAMESPACES").
+				Default("kube-system").
+				String()
 	drainTimeout = kingpin.Flag("drain-timeout", "Max time in second to wait before deleting a node.").
 			Envar("DRAIN_TIMEOUT").
 			Default("300").
 			Int()
-	filters = kingpin.Flag("filters", "Label filters in the form of `key1: value1[, value2[, ...]][; key2: value3[, value4[, ...]], ...]`").
+	drainTimeoutAction = kingpin.Flag("drain-timeout-action", "What to do with the pods left on a node when the drain timeout is reached, `proceed` to kill the node anyway, `abort` to uncordon it and retry later or `delete` to delete the pods.").
+				Envar("DRAIN_TIMEOUT_ACTION").
+				Default("proceed").
+				Enum("proceed", "abort", "delete")
+	expiryPlanning = kingpin.Flag("expiry-planning", "Strategy to pick the expiry of a new node, `random` or `spread` to maximise the gap with the expiries already assigned in the same node pool.").
+			Envar("EXPIRY_PLANNING").
+			Default("random").
+			Enum("random", "spread")
+	filters = kingpin.Flag("filters", "Label selector of the nodes to process like `key1 in (value1, value2), key2 notin (value3), !key3`, or label filters in the form of `key1: value1[, value2[, ...]][; key2: value3[, value4[, ...]], ...]`").
 		Default("").
 		Envar("FILTERS").
 		Short('f').
@@ -52,9 +137,64 @@ var (
 			Default("600").
 			Short('i').
 			Int()
+	killBudget = kingpin.Flag("kill-budget", "Max number of nodes to kill per interval, 0 for no limit.").
+			Envar("KILL_BUDGET").
+			Default("0").
+			Int()
+	killPolicies = kingpin.Flag("kill-policies", "Apply the PreemptibleKillPolicy custom resources of the cluster before the policies of the config file and report their status.").
+			Envar("KILL_POLICIES").
+			Default("false").
+			Bool()
+	killUnhealthy = kingpin.Flag("kill-unhealthy", "Kill nodes that are not ready or have a problem condition right away instead of at their expiry, respecting the kill budget.").
+			Envar("KILL_UNHEALTHY").
+			Default("false").
+			Bool()
 	kubeConfigPath = kingpin.Flag("kubeconfig", "Provide the path to the kube config path, usually located in ~/.kube/config. For out of cluster execution").
 			Envar("KUBECONFIG").
 			String()
+	kubeContext = kingpin.Flag("kube-context", "Context of the kube config to use, defaults to its current context. For out of cluster execution").
+			Envar("KUBE_CONTEXT").
+			String()
+	localStoragePods = kingpin.Flag("local-storage-pods", "What to do when pods with emptyDir volumes, whose data is lost when evicted, are on a node to kill: `proceed`, `postpone` the kill or `refuse` to kill the node.").
+				Envar("LOCAL_STORAGE_PODS").
+				Default("proceed").
+				Enum("proceed", "postpone", "refuse")
+	maximumGracePeriod = kingpin.Flag("maximum-grace-period", "Longest termination grace period pods get when they're evicted, the drain timeout is extended up to it for pods with a longer grace period.").
+				Envar("MAXIMUM_GRACE_PERIOD").
+				Default("10m").
+				Duration()
+	maximumLifetime = kingpin.Flag("maximum-lifetime", "Time after its creation by which a node has to be killed, preemptible VMs are stopped by GCloud after 24 hours.").
+			Envar("MAXIMUM_LIFETIME").
+			Default("24h").
+			Duration()
+	minimumLifetime = kingpin.Flag("minimum-lifetime", "Time after its creation before which a node is not killed, unless it is discovered too late.").
+			Envar("MINIMUM_LIFETIME").
+			Default("12h").
+			Duration()
+	minimumReadyNodes = kingpin.Flag("minimum-ready-nodes", "Number of ready nodes to keep per node pool, kills that would leave less are postponed.").
+				Envar("MINIMUM_READY_NODES").
+				Default("0").
+				Int()
+	nodeNameSeed = kingpin.Flag("node-name-seed", "Derive the random expiry of a node from its name and the random seed, so annotating a node again results in the same expiry.").
+			Envar("NODE_NAME_SEED").
+			Default("false").
+			Bool()
+	randomSeed = kingpin.Flag("random-seed", "Seed for the random number generator, leave 0 to seed from the current time.").
+			Envar("RANDOM_SEED").
+			Default("0").
+			Int64()
+	scaleDownGracePeriod = kingpin.Flag("scale-down-grace-period", "Time after their creation during which nodes can't be removed by the cluster autoscaler, so replacements of killed nodes aren't scaled down right away. 0 leaves them alone.").
+				Envar("SCALE_DOWN_GRACE_PERIOD").
+				Default("0s").
+				Duration()
+	taintFilters = kingpin.Flag("taint-filters", "Taints of the nodes to process in the form of `key1[:effect], !key2[:effect]`, where an exclamation mark excludes the nodes with the taint.").
+			Envar("TAINT_FILTERS").
+			Default("").
+			String()
+	unhealthyGracePeriod = kingpin.Flag("unhealthy-grace-period", "Time a node has to be unhealthy before it's killed right away, so it can recover from short hiccups.").
+				Envar("UNHEALTHY_GRACE_PERIOD").
+				Default("10m").
+				Duration()
 	whitelist = kingpin.Flag("whitelist-hours", "List of UTC time intervals in the form of `09:00 - 12:00, 13:00 - 18:00` in which deletion is allowed and preferred").
 			Envar("WHITELIST_HOURS").
 			Default("").
@@ -67,7 +207,21 @@ var (
 			Name: "estafette_gke_preemptible_killer_node_totals",
 			Help: "Number of processed nodes.",
 		},
-		[]string{"status"},
+		[]string{"cluster", "status"},
+	)
+	drainTotals = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "estafette_gke_preemptible_killer_drain_totals",
+			Help: "Number of drained nodes by how the drain ended.",
+		},
+		[]string{"cluster", "outcome"},
+	)
+	unhealthyNodeTotals = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "estafette_gke_preemptible_killer_unhealthy_node_totals",
+			Help: "Number of nodes killed before their expiry for being unhealthy.",
+		},
+		[]string{"cluster", "condition"},
 	)
 
 	// application version
@@ -78,25 +232,32 @@ var (
 	revision  string
 	buildDate string
 	goVersion = runtime.Version()
-
-	// Various internals
-	randomEstafette   = rand.New(rand.NewSource(time.Now().UnixNano()))
-	labelFilters      = map[string]string{}
-	whitelistInstance WhitelistInstance
 )
 
 func init() {
 	// Metrics have to be registered to be exposed:
 	prometheus.MustRegister(nodeTotals)
+	prometheus.MustRegister(unhealthyNodeTotals)
+	prometheus.MustRegister(drainTotals)
 }
 
 func main() {
 
 	// parse command line parameters
-	kingpin.Parse()
+	command := kingpin.Parse()
+
+	switch command {
+	case planCommand.FullCommand():
+		runPlanCommand(os.Stdout)
+		return
+	case simulateCommand.FullCommand():
+		runSimulateCommand(os.Stdout)
+		return
+	}
 
 	// init log format from envvar ESTAFETTE_LOG_FORMAT
 	foundation.InitLoggingFromEnv(foundation.NewApplicationInfo(appgroup, app, version, branch, revision, buildDate))
+	zerolog.DefaultContextLogger = &log.Logger
 
 	// create context to handle cancellation
 	ctx := foundation.InitCancellationContext(context.Background())
@@ -107,94 +268,381 @@ func main() {
 	// configure prometheus metrics endpoint
 	foundation.InitMetrics()
 
-	// create kubernetes api client
-	kubeClientConfig, err := rest.InClusterConfig()
+	// the clusters and settings from the config file, or the cluster of the command line flags
+	config := Config{}
+	if *configFile != "" {
+		var err error
+		config, err = readConfigFile(*configFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error reading configuration")
+		}
+	}
+
+	// handle kubernetes API crashes
+	defer k8sruntime.HandleCrash()
+
+	clock := NewRealClock()
+
+	seed := *randomSeed
+	if seed == 0 {
+		seed = clock.Now().UnixNano()
+	}
+	random := NewRandom(seed)
+
+	defaultPolicy, err := newDefaultPolicy()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error parsing whitelist and blacklist hours")
+	}
+
+	killers := []*nodeKiller{}
+	for _, cluster := range config.getClusters() {
+		killer, err := newNodeKiller(config, cluster, defaultPolicy, clock, random)
+		if err != nil {
+			log.Fatal().Err(err).Str("cluster", cluster.Name).Msg("Error initializing cluster")
+		}
+		killers = append(killers, killer)
+	}
+
+	// define channel and wait group to gracefully shutdown the application
+	gracefulShutdown, waitGroup := foundation.InitGracefulShutdownHandling()
+
+	// process nodes of every cluster independently
+	for _, killer := range killers {
+		go killer.run(ctx, waitGroup)
+	}
+
+	// handle graceful shutdown after sigterm
+	foundation.HandleGracefulShutdown(gracefulShutdown, waitGroup)
+}
+
+// nodeKiller processes the preemptible nodes of a single cluster
+type nodeKiller struct {
+	cluster          string
+	kubernetesClient KubernetesClient
+	kubeConfigPath   string
+	kubeContext      string
+	clock            Clock
+	random           Random
+	filters          NodeFilter
+	drainOptions     DrainOptions
+	interval         int
+	policy           Policy
+
+	// policies are the node pool policies, the first one matching a node applies instead of the cluster policy
+	policies []Policy
+
+	// configPolicies are the node pool policies of the config file, applied after the kill policies
+	configPolicies []Policy
+
+	// configFile is reloaded at the start of every run when its content differs from configData
+	configFile    string
+	configData    []byte
+	defaultPolicy Policy
+
+	// killPolicies are the PreemptibleKillPolicy custom resources of the current run, when enabled
+	killPolicies        []PreemptibleKillPolicy
+	killPoliciesEnabled bool
+
+	// validKillPolicies are the policies of the valid kill policies, applied before the config policies
+	validKillPolicies []Policy
+
+	// killPolicyStatuses collects the status of the kill policies during the current run
+	killPolicyStatuses map[string]*PreemptibleKillPolicyStatus
+
+	// killsThisRun counts the nodes killed per policy in the current run, to respect the kill budgets
+	killsThisRun map[string]int
+}
+
+// newNodeKiller creates the settings for a cluster and connects to it; a cluster that can't be connected to is retried
+// at every run, so it doesn't keep the other clusters from being processed
+func newNodeKiller(config Config, cluster ClusterConfig, defaultPolicy Policy, clock Clock, random Random) (killer *nodeKiller, err error) {
+	settings, err := config.getClusterSettings(cluster, defaultPolicy)
+	if err != nil {
+		return
+	}
+
+	// create kubernetes api client, in cluster unless a kube config is provided
+	clusterKubeConfigPath := cluster.Kubeconfig
+	if clusterKubeConfigPath == "" {
+		clusterKubeConfigPath = *kubeConfigPath
+	}
+
+	killer = &nodeKiller{
+		cluster:             cluster.Name,
+		kubeConfigPath:      clusterKubeConfigPath,
+		kubeContext:         cluster.Context,
+		clock:               clock,
+		random:              random,
+		configFile:          *configFile,
+		defaultPolicy:       defaultPolicy,
+		killPoliciesEnabled: *killPolicies,
+		killsThisRun:        map[string]int{},
+	}
+	killer.applySettings(settings)
+
+	connectErr := killer.connect()
+	if connectErr != nil {
+		log.Error().Err(connectErr).Str("cluster", cluster.Name).Msg("Error initializing Kubernetes client, retrying at the next run")
+	}
+
+	return
+}
+
+// connect creates the Kubernetes client of the cluster
+func (k *nodeKiller) connect() error {
+	kubeClientConfig, err := NewKubeClientConfig(k.kubeConfigPath, k.kubeContext)
 	if err != nil {
-		log.Fatal().Err(err)
+		return err
 	}
+
 	// creates the clientset
 	kubeClientset, err := kubernetes.NewForConfig(kubeClientConfig)
 	if err != nil {
-		log.Fatal().Err(err)
+		return err
 	}
 
-	// create the shared informer factory and use the client to connect to Kubernetes API
-	// factory := informers.NewSharedInformerFactory(kubeClientset, 0)
+	dynamicClient, err := dynamic.NewForConfig(kubeClientConfig)
+	if err != nil {
+		return err
+	}
 
-	// create a channel to stop the shared informers gracefully
-	stopper := make(chan struct{})
-	defer close(stopper)
+	kubernetesClient, err := NewKubernetesClient(kubeClientset, dynamicClient, k.clock, k.random)
+	if err != nil {
+		return err
+	}
+	k.kubernetesClient = kubernetesClient
 
-	// handle kubernetes API crashes
-	defer k8sruntime.HandleCrash()
+	return nil
+}
 
-	if *filters != "" {
-		*filters = strings.Replace(*filters, " ", "", -1)
-		pairs := strings.Split(*filters, ";")
-		for _, pair := range pairs {
-			keyValue := strings.Split(pair, ":")
+// getSettings returns the settings the cluster currently runs with
+func (k *nodeKiller) getSettings() clusterSettings {
+	return clusterSettings{
+		filters:  k.filters,
+		drain:    k.drainOptions,
+		interval: k.interval,
+		policy:   k.policy,
+		policies: k.configPolicies,
+	}
+}
 
-			// Check format.
-			if len(keyValue) != 2 {
-				panic(fmt.Sprintf("filter '%v' should be of the form `label_key: label_value`", keyValue))
-			}
+// applySettings swaps the settings the cluster runs with
+func (k *nodeKiller) applySettings(settings clusterSettings) {
+	k.filters = settings.filters
+	k.drainOptions = settings.drain
+	k.interval = settings.interval
+	k.policy = settings.policy
+	k.configPolicies = settings.policies
+	k.policies = append(append([]Policy{}, k.validKillPolicies...), k.configPolicies...)
+}
 
-			labelFilters[keyValue[0]] = keyValue[1]
-		}
+// reloadConfig applies the settings of the config file when its content changed since the last run, keeping the
+// current settings when it can't be read or is invalid
+func (k *nodeKiller) reloadConfig(ctx context.Context) {
+	if k.configFile == "" {
+		return
 	}
 
-	whitelistInstance.blacklist = *blacklist
-	whitelistInstance.whitelist = *whitelist
-	whitelistInstance.parseArguments()
+	data, err := os.ReadFile(k.configFile)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msgf("Error reading config file %v, keeping the current settings", k.configFile)
+		return
+	}
+	if bytes.Equal(data, k.configData) {
+		return
+	}
+	k.configData = data
 
-	kubernetesClient, err := NewKubernetesClient(kubeClientset)
+	config, err := parseConfig(k.configFile, data)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Error initializing Kubernetes client")
+		log.Ctx(ctx).Error().Err(err).Msg("Error reloading configuration, keeping the current settings")
+		return
 	}
 
-	// define channel and wait group to gracefully shutdown the application
-	gracefulShutdown, waitGroup := foundation.InitGracefulShutdownHandling()
+	cluster, ok := config.getCluster(k.cluster)
+	if !ok {
+		log.Ctx(ctx).Error().Msgf("Cluster is no longer in config file %v, keeping the current settings until restarted", k.configFile)
+		return
+	}
 
-	// process nodes
-	go func(waitGroup *sync.WaitGroup, kubernetesClient KubernetesClient, ctx context.Context) {
-		for {
-			log.Info().Msg("Listing all preemptible nodes for cluster...")
+	settings, err := config.getClusterSettings(cluster, k.defaultPolicy)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Error reloading configuration, keeping the current settings")
+		return
+	}
 
-			sleepTime := ApplyJitter(*interval)
+	for _, change := range settings.diff(k.getSettings()) {
+		log.Ctx(ctx).Info().Msgf("Reloaded configuration, %v", change)
+	}
 
-			nodes, err := kubernetesClient.GetPreemptibleNodes(ctx, labelFilters)
+	k.applySettings(settings)
+}
 
+// getNodePolicy returns the first node pool policy matching the labels of a node, or the cluster policy
+func (k *nodeKiller) getNodePolicy(node v1.Node) Policy {
+	for _, policy := range k.policies {
+		if policy.Selector.Matches(labels.Set(node.ObjectMeta.Labels)) {
+			return policy
+		}
+	}
+
+	return k.policy
+}
+
+// run processes the nodes of the cluster every interval
+func (k *nodeKiller) run(ctx context.Context, waitGroup *sync.WaitGroup) {
+	// label the logs with the cluster, so all clusters can be told apart
+	logger := log.Logger
+	if k.cluster != "" {
+		logger = logger.With().Str("cluster", k.cluster).Logger()
+	}
+	ctx = logger.WithContext(ctx)
+
+	for {
+		k.reloadConfig(ctx)
+
+		log.Ctx(ctx).Info().Msg("Listing all preemptible nodes for cluster...")
+
+		sleepTime := ApplyJitter(k.random, k.interval)
+
+		if k.kubernetesClient == nil {
+			err := k.connect()
 			if err != nil {
-				log.Error().Err(err).Msg("Error while getting the list of preemptible nodes")
-				log.Info().Msgf("Sleeping for %v seconds...", sleepTime)
-				time.Sleep(time.Duration(sleepTime) * time.Second)
+				log.Ctx(ctx).Error().Err(err).Msg("Error initializing Kubernetes client")
+				log.Ctx(ctx).Info().Msgf("Sleeping for %v seconds...", sleepTime)
+				k.clock.Sleep(time.Duration(sleepTime) * time.Second)
 				continue
 			}
+		}
+
+		nodes, err := k.kubernetesClient.GetPreemptibleNodes(ctx, k.filters)
+
+		if err != nil {
+			log.Ctx(ctx).Error().Err(err).Msg("Error while getting the list of preemptible nodes")
+			log.Ctx(ctx).Info().Msgf("Sleeping for %v seconds...", sleepTime)
+			k.clock.Sleep(time.Duration(sleepTime) * time.Second)
+			continue
+		}
 
-			log.Info().Msgf("Cluster has %v preemptible nodes", len(nodes.Items))
-
-			for _, node := range nodes.Items {
-				waitGroup.Add(1)
-				err := processNode(ctx, kubernetesClient, node)
-				waitGroup.Done()
-
-				if err != nil {
-					nodeTotals.With(prometheus.Labels{"status": "failed"}).Inc()
-					log.Error().
-						Err(err).
-						Str("host", node.ObjectMeta.Name).
-						Msg("Error while processing node")
-					continue
-				}
+		log.Ctx(ctx).Info().Msgf("Cluster has %v preemptible nodes", len(nodes.Items))
+
+		if k.killPoliciesEnabled {
+			k.loadKillPolicies(ctx)
+		}
+
+		// kill unhealthy nodes first, so they get the kill budget before expired healthy ones
+		now := k.clock.Now()
+		sort.SliceStable(nodes.Items, func(a, b int) bool {
+			_, unhealthyA := k.getUnhealthyCondition(now, nodes.Items[a])
+			_, unhealthyB := k.getUnhealthyCondition(now, nodes.Items[b])
+			return unhealthyA && !unhealthyB
+		})
+
+		k.killsThisRun = map[string]int{}
+		for _, node := range nodes.Items {
+			waitGroup.Add(1)
+			err := k.processNode(ctx, node)
+			waitGroup.Done()
+
+			k.recordKillPolicyNode(k.getNodePolicy(node), err)
+
+			if err != nil {
+				nodeTotals.With(prometheus.Labels{"cluster": k.cluster, "status": "failed"}).Inc()
+				log.Ctx(ctx).Error().
+					Err(err).
+					Str("host", node.ObjectMeta.Name).
+					Msg("Error while processing node")
+				continue
 			}
+		}
 
-			log.Info().Msgf("Sleeping for %v seconds...", sleepTime)
-			time.Sleep(time.Duration(sleepTime) * time.Second)
+		if k.killPoliciesEnabled {
+			k.updateKillPolicyStatuses(ctx)
 		}
-	}(waitGroup, kubernetesClient, ctx)
 
-	// handle graceful shutdown after sigterm
-	foundation.HandleGracefulShutdown(gracefulShutdown, waitGroup)
+		log.Ctx(ctx).Info().Msgf("Sleeping for %v seconds...", sleepTime)
+		k.clock.Sleep(time.Duration(sleepTime) * time.Second)
+	}
+}
+
+// loadKillPolicies lists the kill policies and applies the valid ones before the policies of the config file, keeping
+// the previous policies if they can't be listed
+func (k *nodeKiller) loadKillPolicies(ctx context.Context) {
+	killPolicies, err := k.kubernetesClient.GetKillPolicies(ctx)
+	if err != nil {
+		log.Ctx(ctx).Error().Err(err).Msg("Error while getting the list of kill policies, keeping the previous policies")
+		return
+	}
+
+	policies := []Policy{}
+	k.killPolicies = killPolicies
+	k.killPolicyStatuses = map[string]*PreemptibleKillPolicyStatus{}
+	for _, killPolicy := range killPolicies {
+		status := &PreemptibleKillPolicyStatus{}
+		k.killPolicyStatuses[Policy{Source: policySourceKillPolicy, Name: killPolicy.ObjectMeta.Name}.getKey()] = status
+
+		policyConfig := killPolicy.getPolicyConfig()
+		err := validatePolicies([]PolicyConfig{policyConfig})
+		if err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msgf("Kill policy %v is invalid, ignoring it", killPolicy.ObjectMeta.Name)
+			status.LastError = err.Error()
+			continue
+		}
+
+		policy, err := policyConfig.getPolicy(k.policy)
+		if err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msgf("Kill policy %v is invalid, ignoring it", killPolicy.ObjectMeta.Name)
+			status.LastError = err.Error()
+			continue
+		}
+		policy.Source = policySourceKillPolicy
+
+		policies = append(policies, policy)
+	}
+
+	k.validKillPolicies = policies
+	k.policies = append(append([]Policy{}, k.validKillPolicies...), k.configPolicies...)
+}
+
+// recordKillPolicyNode counts a processed node in the status of its kill policy, if any
+func (k *nodeKiller) recordKillPolicyNode(policy Policy, err error) {
+	status, ok := k.killPolicyStatuses[policy.getKey()]
+	if !ok {
+		return
+	}
+
+	status.NodesManaged++
+	if err != nil {
+		status.LastError = err.Error()
+	}
+}
+
+// recordKillPolicyExpiry keeps the earliest expiry of the nodes of a kill policy as its next kill
+func (k *nodeKiller) recordKillPolicyExpiry(policy Policy, expiryDatetime time.Time) {
+	status, ok := k.killPolicyStatuses[policy.getKey()]
+	if !ok {
+		return
+	}
+
+	if status.NextKill == nil || expiryDatetime.Before(status.NextKill.Time) {
+		status.NextKill = &metav1.Time{Time: expiryDatetime}
+	}
+}
+
+// updateKillPolicyStatuses writes the status collected during the run to the kill policies that changed
+func (k *nodeKiller) updateKillPolicyStatuses(ctx context.Context) {
+	for _, killPolicy := range k.killPolicies {
+		status, ok := k.killPolicyStatuses[Policy{Source: policySourceKillPolicy, Name: killPolicy.ObjectMeta.Name}.getKey()]
+		if !ok || apiequality.Semantic.DeepEqual(killPolicy.Status, *status) {
+			continue
+		}
+
+		killPolicy.Status = *status
+		err := k.kubernetesClient.UpdateKillPolicyStatus(ctx, killPolicy)
+		if err != nil {
+			log.Ctx(ctx).Warn().Err(err).Msgf("Error updating status of kill policy %v", killPolicy.ObjectMeta.Name)
+		}
+	}
 }
 
 // getCurrentNodeState return the state of the node by reading its metadata annotations
@@ -209,68 +657,211 @@ func getCurrentNodeState(node v1.Node) (state GKEPreemptibleKillerState) {
 	return
 }
 
-// getDesiredNodeState define the state of the node, update node annotations if not present
-func getDesiredNodeState(now time.Time, ctx context.Context, kubernetesClient KubernetesClient, node v1.Node) (state GKEPreemptibleKillerState, err error) {
-
-	twelveHours := 12 * time.Hour
-	twentyFourHours := 24 * time.Hour
+// getExpiryOffsetBounds returns the bounds of the offset from now at which a node should expire, so it gets killed
+// between the minimum and maximum lifetime (12 and 24 hours by default) while leaving enough time to drain it
+func getExpiryOffsetBounds(now time.Time, policy Policy, node v1.Node) (minimumOffset, maximumOffset time.Duration) {
 
-	drainTimeoutTime := time.Duration(*drainTimeout) * time.Second
+	drainDuration := policy.getMaximumDrainDuration()
 
 	creationTime := node.ObjectMeta.CreationTimestamp.Time
-	nodeDeletedBy := creationTime.Add(twentyFourHours)
+	nodeDeletedBy := creationTime.Add(policy.MaximumLifetime)
 
 	expectedRemainingLife := time.Duration(math.Max(float64(nodeDeletedBy.Sub(now)), 0))
-	kickOffDeletionBy := time.Duration(math.Max(float64(expectedRemainingLife-drainTimeoutTime), 0))
+	kickOffDeletionBy := time.Duration(math.Max(float64(expectedRemainingLife-drainDuration), 0))
+
+	if expectedRemainingLife > policy.MinimumLifetime {
+		minimumOffset = policy.MinimumLifetime
+	}
+	maximumOffset = kickOffDeletionBy
+
+	return
+}
+
+// calculateExpiryDate returns a random expiry datetime for a node, respecting the whitelist
+func calculateExpiryDate(now time.Time, policy Policy, random Random, node v1.Node) time.Time {
+	minimumOffset, maximumOffset := getExpiryOffsetBounds(now, policy, node)
 
 	var randomOffset time.Duration
-	if kickOffDeletionBy > 0 {
-		randomOffset = time.Duration(randomEstafette.Intn(int(kickOffDeletionBy)))
+	if maximumOffset > 0 {
+		randomOffset = time.Duration(random.Int63n(int64(maximumOffset)))
+	}
+	if randomOffset < minimumOffset {
+		randomOffset += minimumOffset
+	}
+
+	return policy.Whitelist.getExpiryDate(now, randomOffset)
+}
+
+// planExpiryDate returns the expiry datetime for a node, spreading it away from the existing expiry dates of its node
+// pool when the spread strategy is used
+func planExpiryDate(now time.Time, policy Policy, random Random, node v1.Node, existingExpiryDates []time.Time) (expiryDateTime time.Time) {
+	if policy.ExpiryPlanning == "spread" && len(existingExpiryDates) > 0 {
+		minimumOffset, maximumOffset := getExpiryOffsetBounds(now, policy, node)
+		if maximumOffset < minimumOffset {
+			maximumOffset = minimumOffset
+		}
+
+		return planSpreadExpiryDate(now, policy, minimumOffset, maximumOffset, existingExpiryDates)
+	}
+
+	if *nodeNameSeed {
+		// anchor the expiry at the creation of the node, so annotating it again results in the same expiry
+		random = NewNodeRandom(node.ObjectMeta.Name, *randomSeed)
+		expiryDateTime = calculateExpiryDate(node.ObjectMeta.CreationTimestamp.Time, policy, random, node)
 	}
-	if expectedRemainingLife > twelveHours && randomOffset < twelveHours {
-		randomOffset += twelveHours
+	if !expiryDateTime.After(now) {
+		expiryDateTime = calculateExpiryDate(now, policy, random, node)
+	}
+
+	return
+}
+
+// getDesiredNodeState define the state of the node, update node annotations if not present
+func (k *nodeKiller) getDesiredNodeState(ctx context.Context, now time.Time, node v1.Node) (state GKEPreemptibleKillerState, err error) {
+	policy := k.getNodePolicy(node)
+
+	var existingExpiryDates []time.Time
+	if policy.ExpiryPlanning == "spread" {
+		existingExpiryDates, err = k.getNodePoolExpiryDates(ctx, node)
+		if err != nil {
+			log.Ctx(ctx).Warn().
+				Err(err).
+				Str("host", node.ObjectMeta.Name).
+				Msg("Error getting expiry dates of node pool, falling back to random expiry")
+			err = nil
+		}
 	}
 
-	expiryDateTime := whitelistInstance.getExpiryDate(now, randomOffset)
+	expiryDateTime := planExpiryDate(now, policy, k.random, node, existingExpiryDates)
 	state.ExpiryDatetime = expiryDateTime.Format(time.RFC3339)
 
-	log.Info().
+	log.Ctx(ctx).Info().
 		Str("host", node.ObjectMeta.Name).
 		Msgf("Annotation not found, adding %s to %s", annotationGKEPreemptibleKillerState, state.ExpiryDatetime)
 
-	err = kubernetesClient.SetNodeAnnotation(ctx, node.ObjectMeta.Name, annotationGKEPreemptibleKillerState, state.ExpiryDatetime)
+	err = k.kubernetesClient.SetNodeAnnotation(ctx, node.ObjectMeta.Name, annotationGKEPreemptibleKillerState, state.ExpiryDatetime)
 
 	if err != nil {
-		log.Warn().
+		log.Ctx(ctx).Warn().
 			Err(err).
 			Str("host", node.ObjectMeta.Name).
 			Msg("Error updating node metadata")
 
-		nodeTotals.With(prometheus.Labels{"status": "failed"}).Inc()
+		nodeTotals.With(prometheus.Labels{"cluster": k.cluster, "status": "failed"}).Inc()
 		return
 	}
 
-	nodeTotals.With(prometheus.Labels{"status": "annotated"}).Inc()
+	nodeTotals.With(prometheus.Labels{"cluster": k.cluster, "status": "annotated"}).Inc()
+
+	return
+}
+
+// getNodePoolExpiryDates returns the expiry datetimes already assigned to the other nodes of the node pool of a given node
+func (k *nodeKiller) getNodePoolExpiryDates(ctx context.Context, node v1.Node) (expiryDates []time.Time, err error) {
+	poolFilter := k.filters
+	if nodePool, ok := node.ObjectMeta.Labels[labelGKENodePool]; ok {
+		poolFilter, err = k.filters.withLabel(labelGKENodePool, nodePool)
+		if err != nil {
+			return
+		}
+	}
+
+	nodes, err := k.kubernetesClient.GetPreemptibleNodes(ctx, poolFilter)
+	if err != nil {
+		return
+	}
+
+	for _, poolNode := range nodes.Items {
+		if poolNode.ObjectMeta.Name == node.ObjectMeta.Name {
+			continue
+		}
+
+		state := getCurrentNodeState(poolNode)
+		if state.ExpiryDatetime == "" {
+			continue
+		}
+
+		expiryDatetime, parseErr := time.Parse(time.RFC3339, state.ExpiryDatetime)
+		if parseErr != nil {
+			continue
+		}
+
+		expiryDates = append(expiryDates, expiryDatetime)
+	}
+
+	return
+}
+
+// planSpreadExpiryDate picks the expiry datetime between the given offsets that maximises the gap with existing expiry
+// dates, offsets are handled the same way as the random offset so the whitelist is respected
+func planSpreadExpiryDate(now time.Time, policy Policy, minimumOffset, maximumOffset time.Duration, existingExpiryDates []time.Time) (expiryDatetime time.Time) {
+	largestGap := time.Duration(-1)
+
+	for offset := minimumOffset; offset <= maximumOffset; offset += spreadPlanningStep {
+		candidate := policy.Whitelist.getExpiryDate(now, offset)
+		if candidate.IsZero() {
+			continue
+		}
+
+		gap := time.Duration(math.MaxInt64)
+		for _, existingExpiryDate := range existingExpiryDates {
+			distance := candidate.Sub(existingExpiryDate)
+			if distance < 0 {
+				distance = -distance
+			}
+			if distance < gap {
+				gap = distance
+			}
+		}
+
+		if gap > largestGap {
+			largestGap = gap
+			expiryDatetime = candidate
+		}
+	}
 
 	return
 }
 
 // processNode returns the time to delete a node after n minutes
-func processNode(ctx context.Context, kubernetesClient KubernetesClient, node v1.Node) (err error) {
+func (k *nodeKiller) processNode(ctx context.Context, node v1.Node) (err error) {
+	policy := k.getNodePolicy(node)
+
+	if !policy.Enabled {
+		nodeTotals.With(prometheus.Labels{"cluster": k.cluster, "status": "skipped"}).Inc()
+
+		log.Ctx(ctx).Debug().
+			Str("host", node.ObjectMeta.Name).
+			Msgf("Policy %v is disabled, skipping node", policy.Name)
+		return
+	}
+
+	// leave nodes the cluster autoscaler is already removing
+	if (TaintFilter{Key: taintToBeDeletedByClusterAutoscaler}).matchesAny(node.Spec.Taints) {
+		nodeTotals.With(prometheus.Labels{"cluster": k.cluster, "status": "skipped"}).Inc()
+
+		log.Ctx(ctx).Info().
+			Str("host", node.ObjectMeta.Name).
+			Msg("Node is being removed by the cluster autoscaler, skipping")
+		return
+	}
+
+	k.updateScaleDownProtection(ctx, k.clock.Now(), node, policy)
+
 	// get current node state
 	state := getCurrentNodeState(node)
 
 	// set node state if doesn't already have annotations
 	if state.ExpiryDatetime == "" {
-		state, _ = getDesiredNodeState(time.Now(), ctx, kubernetesClient, node)
+		state, _ = k.getDesiredNodeState(ctx, k.clock.Now(), node)
 	}
 
 	// compute time difference
-	now := time.Now().UTC()
+	now := k.clock.Now().UTC()
 	expiryDatetime, err := time.Parse(time.RFC3339, state.ExpiryDatetime)
 
 	if err != nil {
-		log.Error().
+		log.Ctx(ctx).Error().
 			Err(err).
 			Str("host", node.ObjectMeta.Name).
 			Msgf("Error parsing expiry datetime with value '%s'", state.ExpiryDatetime)
@@ -279,103 +870,539 @@ func processNode(ctx context.Context, kubernetesClient KubernetesClient, node v1
 
 	timeDiff := expiryDatetime.Sub(now).Minutes()
 
+	// unhealthy nodes are of no use, so they don't wait for their expiry
+	unhealthyCondition, unhealthy := k.getUnhealthyCondition(now, node)
+
 	// check if we need to delete the node or not
-	if timeDiff < 0 {
-		log.Info().
-			Str("host", node.ObjectMeta.Name).
-			Msgf("Node expired %.0f minute(s) ago, deleting...", timeDiff)
+	if timeDiff < 0 || unhealthy {
+		reason := fmt.Sprintf("Node expired %.0f minute(s) ago", timeDiff)
+		if unhealthy {
+			reason = fmt.Sprintf("Node has condition %v with status %v since %v", unhealthyCondition.Type, unhealthyCondition.Status, unhealthyCondition.LastTransitionTime.Time.Format(time.RFC3339))
+		}
 
-		// set node unschedulable
-		err = kubernetesClient.SetUnschedulableState(ctx, node.ObjectMeta.Name, true)
-		if err != nil {
-			log.Error().
-				Err(err).
+		if policy.KillBudget > 0 && k.killsThisRun[policy.getKey()] >= policy.KillBudget {
+			nodeTotals.With(prometheus.Labels{"cluster": k.cluster, "status": "postponed"}).Inc()
+
+			log.Ctx(ctx).Info().
 				Str("host", node.ObjectMeta.Name).
-				Msg("Error setting node to unschedulable state")
+				Msgf("%v, but the kill budget of %d node(s) is used up for policy %v, postponing to next run", reason, policy.KillBudget, policy.Name)
+
+			k.recordKillPolicyExpiry(policy, expiryDatetime)
 			return
 		}
 
-		var projectID string
-		var zone string
-		projectID, zone, err = kubernetesClient.GetProjectIdAndZoneFromNode(ctx, node.ObjectMeta.Name)
+		// once there's no time left to drain the node within its maximum lifetime it's killed regardless of what would
+		// postpone its kill, postponing it again would only keep it until it's preempted
+		postponable := getLatestKickOff(policy, node).After(now)
+		if !postponable {
+			log.Ctx(ctx).Info().
+				Str("host", node.ObjectMeta.Name).
+				Msgf("%v, and its maximum lifetime leaves no time to postpone its kill", reason)
+
+			if policy.LocalStoragePods == unsafePodsActionPostpone {
+				policy.LocalStoragePods = unsafePodsActionProceed
+			}
+			if policy.BarePods == unsafePodsActionPostpone {
+				policy.BarePods = unsafePodsActionProceed
+			}
+		}
+
+		// killing a ready node must leave enough ready nodes in its node pool
+		if postponable && policy.MinimumReadyNodes > 0 && isNodeReady(node) {
+			var readyNodes int
+			readyNodes, err = k.countReadyNodePoolNodes(ctx, node)
+			if err != nil {
+				log.Ctx(ctx).Error().
+					Err(err).
+					Str("host", node.ObjectMeta.Name).
+					Msg("Error counting the ready nodes of the node pool")
+				return
+			}
+
+			if readyNodes-1 < policy.MinimumReadyNodes {
+				nodeTotals.With(prometheus.Labels{"cluster": k.cluster, "status": "postponed"}).Inc()
 
+				log.Ctx(ctx).Info().
+					Str("host", node.ObjectMeta.Name).
+					Msgf("%v, but the node pool has %d ready node(s) and has to keep %d, postponing", reason, readyNodes, policy.MinimumReadyNodes)
+
+				return k.postponeNode(ctx, now, node, policy)
+			}
+		}
+
+		// cordoning a node with pods that would be lost only for the drain to refuse to start would leave it
+		// unschedulable for nothing, and use up the kill budget every run
+		var unsafePods DrainResult
+		unsafePods, err = k.kubernetesClient.GetUnsafePods(ctx, node.ObjectMeta.Name, k.getDrainOptions(policy))
 		if err != nil {
-			log.Error().
+			log.Ctx(ctx).Error().
 				Err(err).
 				Str("host", node.ObjectMeta.Name).
-				Msg("Error getting project id and zone from node")
+				Msg("Error checking the pods on the node for local storage and controllers")
 			return
 		}
 
-		var gcloud GCloudClient
-		gcloud, err = NewGCloudClient(projectID, zone)
+		// no drain runs, so it's only logged rather than recorded as a drain with an event on the node every run
+		switch unsafePods.Outcome {
+		case DrainOutcomePostponed:
+			nodeTotals.With(prometheus.Labels{"cluster": k.cluster, "status": "postponed"}).Inc()
 
-		if err != nil {
-			log.Error().
-				Err(err).
+			log.Ctx(ctx).Info().
+				Str("host", node.ObjectMeta.Name).
+				Strs("localStoragePods", unsafePods.LocalStoragePods).
+				Strs("barePods", unsafePods.BarePods).
+				Msgf("%v, but the policy postpones killing nodes with pods that would be lost, postponing", reason)
+
+			return k.postponeNode(ctx, now, node, policy)
+		case DrainOutcomeRefused:
+			nodeTotals.With(prometheus.Labels{"cluster": k.cluster, "status": "postponed"}).Inc()
+
+			log.Ctx(ctx).Info().
 				Str("host", node.ObjectMeta.Name).
-				Msg("Error creating GCloud client")
+				Strs("localStoragePods", unsafePods.LocalStoragePods).
+				Strs("barePods", unsafePods.BarePods).
+				Msgf("%v, but the policy refuses to kill nodes with pods that would be lost, keeping node", reason)
+
+			k.recordKillPolicyExpiry(policy, expiryDatetime)
 			return
 		}
 
-		// drain kubernetes node
-		err = kubernetesClient.DrainNode(ctx, node.ObjectMeta.Name, *drainTimeout)
+		// cordoning a node whose pods can't be evicted would only leave it unschedulable until the drain times out,
+		// unless its pods get deleted regardless of their budgets or the node is unhealthy and has to go anyway
+		if postponable && !unhealthy && !(policy.DrainTimeoutAction == drainTimeoutActionDelete && policy.DeleteIgnorePDBs) {
+			var blockedPods []string
+			blockedPods, err = k.kubernetesClient.GetBlockedPods(ctx, node.ObjectMeta.Name, k.getDrainOptions(policy))
+			if err != nil {
+				log.Ctx(ctx).Error().
+					Err(err).
+					Str("host", node.ObjectMeta.Name).
+					Msg("Error checking the pod disruption budgets of the pods on the node")
+				return
+			}
+
+			if len(blockedPods) > 0 {
+				nodeTotals.With(prometheus.Labels{"cluster": k.cluster, "status": "postponed"}).Inc()
+
+				log.Ctx(ctx).Info().
+					Str("host", node.ObjectMeta.Name).
+					Msgf("%v, but pod disruption budgets allow no disruption of pod(s) %v, postponing", reason, strings.Join(blockedPods, ", "))
 
+				return k.postponeNode(ctx, now, node, policy)
+			}
+		}
+
+		k.killsThisRun[policy.getKey()]++
+
+		log.Ctx(ctx).Info().
+			Str("host", node.ObjectMeta.Name).
+			Msgf("%v, deleting...", reason)
+
+		var killed bool
+		killed, err = k.killNode(ctx, node, policy, postponable)
 		if err != nil {
-			log.Error().
-				Err(err).
-				Str("host", node.ObjectMeta.Name).
-				Msg("Error draining kubernetes node")
 			return
 		}
+		if !killed {
+			nodeTotals.With(prometheus.Labels{"cluster": k.cluster, "status": "postponed"}).Inc()
+			return
+		}
+
+		nodeTotals.With(prometheus.Labels{"cluster": k.cluster, "status": "killed"}).Inc()
+		if unhealthy {
+			unhealthyNodeTotals.With(prometheus.Labels{"cluster": k.cluster, "condition": string(unhealthyCondition.Type)}).Inc()
+		}
+
+		log.Ctx(ctx).Info().
+			Str("host", node.ObjectMeta.Name).
+			Msg("Node deleted")
+
+		return
+	}
+
+	nodeTotals.With(prometheus.Labels{"cluster": k.cluster, "status": "skipped"}).Inc()
+
+	log.Ctx(ctx).Info().
+		Str("host", node.ObjectMeta.Name).
+		Msgf("%.0f minute(s) to go before kill, keeping node", timeDiff)
+
+	k.recordKillPolicyExpiry(policy, expiryDatetime)
+
+	return
+}
+
+// postponeNode pushes the expiry of a node forward, within its remaining lifetime unless that has run out
+func (k *nodeKiller) postponeNode(ctx context.Context, now time.Time, node v1.Node, policy Policy) (err error) {
+	expiryDatetime := getPostponedExpiryDate(now, policy, node)
 
-		// drain kube-dns from kubernetes node
-		err = kubernetesClient.DrainKubeDNSFromNode(ctx, node.ObjectMeta.Name, *drainTimeout)
+	err = k.kubernetesClient.SetNodeAnnotation(ctx, node.ObjectMeta.Name, annotationGKEPreemptibleKillerState, expiryDatetime.Format(time.RFC3339))
+	if err != nil {
+		log.Ctx(ctx).Warn().
+			Err(err).
+			Str("host", node.ObjectMeta.Name).
+			Msg("Error updating node metadata")
+		return
+	}
+
+	log.Ctx(ctx).Info().
+		Str("host", node.ObjectMeta.Name).
+		Msgf("Postponed %s to %s", annotationGKEPreemptibleKillerState, expiryDatetime.Format(time.RFC3339))
+
+	k.recordKillPolicyExpiry(policy, expiryDatetime)
 
+	return
+}
+
+// getPostponedExpiryDate returns the expiry of a postponed node, the postpone delay from now but no later than the
+// latest kick off within its maximum lifetime
+func getPostponedExpiryDate(now time.Time, policy Policy, node v1.Node) time.Time {
+	expiryDatetime := now.Add(postponeDelay).UTC().Truncate(time.Second)
+
+	latestKickOff := getLatestKickOff(policy, node)
+	if latestKickOff.After(now) && latestKickOff.Before(expiryDatetime) {
+		expiryDatetime = latestKickOff.UTC()
+	}
+
+	return expiryDatetime
+}
+
+// getLatestKickOff returns the last time the kill of a node can start while leaving it the time to drain before the end
+// of its maximum lifetime
+func getLatestKickOff(policy Policy, node v1.Node) time.Time {
+	return node.ObjectMeta.CreationTimestamp.Time.Add(policy.MaximumLifetime).Add(-policy.getMaximumDrainDuration())
+}
+
+// countReadyNodePoolNodes counts the ready and schedulable preemptible nodes of the node pool of a given node
+func (k *nodeKiller) countReadyNodePoolNodes(ctx context.Context, node v1.Node) (readyNodes int, err error) {
+	poolFilter := k.filters
+	if nodePool, ok := node.ObjectMeta.Labels[labelGKENodePool]; ok {
+		poolFilter, err = NodeFilter{}.withLabel(labelGKENodePool, nodePool)
 		if err != nil {
-			log.Error().
-				Err(err).
-				Str("host", node.ObjectMeta.Name).
-				Msg("Error draining kube-dns from kubernetes node")
 			return
 		}
+	}
 
-		// delete node from kubernetes cluster
-		err = kubernetesClient.DeleteNode(ctx, node.ObjectMeta.Name)
+	nodes, err := k.kubernetesClient.GetPreemptibleNodes(ctx, poolFilter)
+	if err != nil {
+		return
+	}
+
+	for _, poolNode := range nodes.Items {
+		if isNodeReady(poolNode) && !poolNode.Spec.Unschedulable {
+			readyNodes++
+		}
+	}
 
+	return
+}
+
+// isNodeReady returns whether the Ready condition of a node is True
+func isNodeReady(node v1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady {
+			return condition.Status == v1.ConditionTrue
+		}
+	}
+
+	return false
+}
+
+// updateScaleDownProtection stops the cluster autoscaler from removing a node during the scale down grace period after
+// its creation, and allows it again afterwards unless someone else disabled scale down of the node
+func (k *nodeKiller) updateScaleDownProtection(ctx context.Context, now time.Time, node v1.Node, policy Policy) {
+	protectedUntil := node.ObjectMeta.CreationTimestamp.Time.Add(policy.ScaleDownGracePeriod)
+	_, protectedByKiller := node.ObjectMeta.Annotations[annotationGKEPreemptibleKillerScaleDownDisabledUntil]
+
+	if now.Before(protectedUntil) && !protectedByKiller {
+		if node.ObjectMeta.Annotations[annotationScaleDownDisabled] == "true" {
+			return
+		}
+
+		log.Ctx(ctx).Info().
+			Str("host", node.ObjectMeta.Name).
+			Msgf("Disabling scale down by the cluster autoscaler until %s", protectedUntil.UTC().Format(time.RFC3339))
+
+		err := k.kubernetesClient.SetNodeAnnotation(ctx, node.ObjectMeta.Name, annotationGKEPreemptibleKillerScaleDownDisabledUntil, protectedUntil.UTC().Format(time.RFC3339))
+		if err == nil {
+			err = k.kubernetesClient.SetNodeAnnotation(ctx, node.ObjectMeta.Name, annotationScaleDownDisabled, "true")
+		}
 		if err != nil {
-			log.Error().
+			log.Ctx(ctx).Warn().
 				Err(err).
 				Str("host", node.ObjectMeta.Name).
-				Msg("Error deleting node")
-			return
+				Msg("Error disabling scale down by the cluster autoscaler")
 		}
+		return
+	}
 
-		// delete gcloud instance
-		err = gcloud.DeleteNode(node.ObjectMeta.Name)
+	if !now.Before(protectedUntil) && protectedByKiller {
+		log.Ctx(ctx).Info().
+			Str("host", node.ObjectMeta.Name).
+			Msg("Scale down grace period is over, enabling scale down by the cluster autoscaler")
 
+		err := k.kubernetesClient.RemoveNodeAnnotation(ctx, node.ObjectMeta.Name, annotationScaleDownDisabled)
+		if err == nil {
+			err = k.kubernetesClient.RemoveNodeAnnotation(ctx, node.ObjectMeta.Name, annotationGKEPreemptibleKillerScaleDownDisabledUntil)
+		}
 		if err != nil {
-			log.Error().
+			log.Ctx(ctx).Warn().
 				Err(err).
 				Str("host", node.ObjectMeta.Name).
-				Msg("Error deleting GCloud instance")
+				Msg("Error enabling scale down by the cluster autoscaler")
+		}
+	}
+}
+
+// killNode cordons, drains and deletes a node from the cluster and its instance from GCloud; unless the node has no
+// lifetime left to postpone its kill, it's uncordoned instead when the drain is aborted, to postpone the kill, or fails,
+// to try again at the next run
+func (k *nodeKiller) killNode(ctx context.Context, node v1.Node, policy Policy, postponable bool) (killed bool, err error) {
+	// keep the cluster autoscaler from removing the node while it's being drained
+	err = k.kubernetesClient.SetNodeAnnotation(ctx, node.ObjectMeta.Name, annotationScaleDownDisabled, "true")
+	if err != nil {
+		log.Ctx(ctx).Error().
+			Err(err).
+			Str("host", node.ObjectMeta.Name).
+			Msg("Error disabling scale down by the cluster autoscaler")
+		return
+	}
+
+	// set node unschedulable
+	err = k.kubernetesClient.SetUnschedulableState(ctx, node.ObjectMeta.Name, true)
+	if err != nil {
+		log.Ctx(ctx).Error().
+			Err(err).
+			Str("host", node.ObjectMeta.Name).
+			Msg("Error setting node to unschedulable state")
+		return
+	}
+
+	var projectID string
+	var zone string
+	projectID, zone, err = k.kubernetesClient.GetProjectIdAndZoneFromNode(ctx, node.ObjectMeta.Name)
+
+	if err != nil {
+		log.Ctx(ctx).Error().
+			Err(err).
+			Str("host", node.ObjectMeta.Name).
+			Msg("Error getting project id and zone from node")
+		return
+	}
+
+	var gcloud GCloudClient
+	gcloud, err = newGCloudClient(projectID, zone)
+
+	if err != nil {
+		log.Ctx(ctx).Error().
+			Err(err).
+			Str("host", node.ObjectMeta.Name).
+			Msg("Error creating GCloud client")
+		return
+	}
+
+	// drain kubernetes node
+	drainResult, err := k.kubernetesClient.DrainNode(ctx, node.ObjectMeta.Name, policy.DrainTimeout, k.getDrainOptions(policy))
+	k.recordDrainResult(ctx, node, drainResult)
+
+	if err != nil {
+		// a node without lifetime left to try again later is killed anyway
+		if !postponable {
+			log.Ctx(ctx).Error().
+				Err(err).
+				Str("host", node.ObjectMeta.Name).
+				Msg("Error draining kubernetes node, deleting it anyway as its maximum lifetime leaves no time to try again")
+		} else {
+			log.Ctx(ctx).Error().
+				Err(err).
+				Str("host", node.ObjectMeta.Name).
+				Msg("Error draining kubernetes node, trying again at the next run")
+
+			releaseErr := k.releaseNode(ctx, node)
+			if releaseErr != nil {
+				log.Ctx(ctx).Warn().
+					Err(releaseErr).
+					Str("host", node.ObjectMeta.Name).
+					Msg("Error releasing node after failed drain")
+			}
 			return
 		}
+	}
 
-		nodeTotals.With(prometheus.Labels{"status": "killed"}).Inc()
+	switch drainResult.Outcome {
+	case DrainOutcomeAborted, DrainOutcomePostponed:
+		// a node without lifetime left to postpone its kill is killed with the pods still on it
+		if postponable {
+			err = k.abortKill(ctx, node, policy)
+			return
+		}
 
-		log.Info().
+		log.Ctx(ctx).Warn().
 			Str("host", node.ObjectMeta.Name).
-			Msg("Node deleted")
+			Msgf("Drain %v, but the maximum lifetime of the node leaves no time to postpone its kill, deleting it anyway", drainResult.Outcome)
+	case DrainOutcomeRefused:
+		err = k.releaseNode(ctx, node)
+		return
+	}
+
+	// delete node from kubernetes cluster
+	err = k.kubernetesClient.DeleteNode(ctx, node.ObjectMeta.Name)
 
+	if err != nil {
+		log.Ctx(ctx).Error().
+			Err(err).
+			Str("host", node.ObjectMeta.Name).
+			Msg("Error deleting node")
 		return
 	}
 
-	nodeTotals.With(prometheus.Labels{"status": "skipped"}).Inc()
+	// delete gcloud instance
+	err = gcloud.DeleteNode(node.ObjectMeta.Name)
 
-	log.Info().
+	if err != nil {
+		log.Ctx(ctx).Error().
+			Err(err).
+			Str("host", node.ObjectMeta.Name).
+			Msg("Error deleting GCloud instance")
+		return
+	}
+
+	return true, nil
+}
+
+// getDrainOptions returns the drain options of the cluster completed with the drain settings of a policy
+func (k *nodeKiller) getDrainOptions(policy Policy) DrainOptions {
+	options := k.drainOptions
+	options.MaximumGracePeriod = policy.MaximumGracePeriod
+	options.TimeoutAction = policy.DrainTimeoutAction
+	options.DeleteGracePeriod = policy.DeleteGracePeriod
+	options.DeleteIgnorePDBs = policy.DeleteIgnorePDBs
+	options.LocalStoragePods = policy.LocalStoragePods
+	options.BarePods = policy.BarePods
+
+	return options
+}
+
+// recordDrainResult logs and counts the outcome of draining a node and records it as an event on the node
+func (k *nodeKiller) recordDrainResult(ctx context.Context, node v1.Node, result DrainResult) {
+	log.Ctx(ctx).Info().
 		Str("host", node.ObjectMeta.Name).
-		Msgf("%.0f minute(s) to go before kill, keeping node", timeDiff)
+		Str("outcome", string(result.Outcome)).
+		Strs("evictedPods", result.EvictedPods).
+		Strs("gonePods", result.GonePods).
+		Interface("blockedPods", result.BlockedPods).
+		Interface("failedPods", result.FailedPods).
+		Strs("remainingPods", result.RemainingPods).
+		Dur("duration", result.Duration).
+		Msgf("Drain %v: %v", result.Outcome, result)
+
+	drainTotals.With(prometheus.Labels{"cluster": k.cluster, "outcome": string(result.Outcome)}).Inc()
+
+	eventType := v1.EventTypeWarning
+	reason := "DrainTimeout"
+	message := fmt.Sprintf("Drain timeout reached with %d pod(s) remaining (%v)", len(result.RemainingPods), strings.Join(result.RemainingPods, ", "))
+	switch result.Outcome {
+	case DrainOutcomeDrained:
+		eventType = v1.EventTypeNormal
+		reason = "Drained"
+		message = fmt.Sprintf("Drained the node before killing it, %v", result)
+	case DrainOutcomeProceeded:
+		message += ", killing the node anyway"
+	case DrainOutcomeAborted:
+		message += ", aborting the kill"
+	case DrainOutcomeDeleted:
+		message += ", deleted the pods"
+	case DrainOutcomePostponed:
+		reason = "DrainPostponed"
+		message = "Postponed the kill for pods that would be lost"
+	case DrainOutcomeRefused:
+		reason = "DrainRefused"
+		message = "Refused to kill the node for pods that would be lost"
+	case DrainOutcomeFailed:
+		reason = "DrainFailed"
+		message = fmt.Sprintf("Failed to drain the node, %v", result)
+	}
+
+	if len(result.LocalStoragePods) > 0 {
+		message += fmt.Sprintf("; pod(s) with local storage: %v", strings.Join(result.LocalStoragePods, ", "))
+	}
+	if len(result.BarePods) > 0 {
+		message += fmt.Sprintf("; pod(s) without controller: %v", strings.Join(result.BarePods, ", "))
+	}
+	for _, pod := range result.RemainingPods {
+		if blockedReason, ok := result.BlockedPods[pod]; ok {
+			message += fmt.Sprintf("; %v blocked: %v", pod, blockedReason)
+		}
+	}
+	for _, pod := range sortedKeys(result.FailedPods) {
+		message += fmt.Sprintf("; %v failed: %v", pod, result.FailedPods[pod])
+	}
+
+	err := k.kubernetesClient.CreateNodeEvent(ctx, node, eventType, reason, message)
+	if err != nil {
+		log.Ctx(ctx).Warn().
+			Err(err).
+			Str("host", node.ObjectMeta.Name).
+			Msg("Error creating drain event")
+	}
+}
+
+// abortKill makes a node schedulable again after an aborted drain and postpones its kill
+func (k *nodeKiller) abortKill(ctx context.Context, node v1.Node, policy Policy) (err error) {
+	err = k.releaseNode(ctx, node)
+	if err != nil {
+		return
+	}
+
+	return k.postponeNode(ctx, k.clock.Now(), node, policy)
+}
+
+// releaseNode makes a node schedulable again and lets the cluster autoscaler remove it, when its kill doesn't go ahead
+func (k *nodeKiller) releaseNode(ctx context.Context, node v1.Node) (err error) {
+	err = k.kubernetesClient.SetUnschedulableState(ctx, node.ObjectMeta.Name, false)
+	if err != nil {
+		log.Ctx(ctx).Error().
+			Err(err).
+			Str("host", node.ObjectMeta.Name).
+			Msg("Error setting node to schedulable state")
+		return
+	}
+
+	// leave the annotation when it was there before the kill, the scale down protection manages it then
+	if _, ok := node.ObjectMeta.Annotations[annotationScaleDownDisabled]; !ok {
+		err = k.kubernetesClient.RemoveNodeAnnotation(ctx, node.ObjectMeta.Name, annotationScaleDownDisabled)
+		if err != nil {
+			log.Ctx(ctx).Error().
+				Err(err).
+				Str("host", node.ObjectMeta.Name).
+				Msg("Error enabling scale down by the cluster autoscaler")
+			return
+		}
+	}
+
+	return
+}
+
+// getUnhealthyCondition returns the condition that makes a node unhealthy for longer than the grace period of its
+// policy, when the policy kills unhealthy nodes: the Ready condition not being True, or any other condition like
+// MemoryPressure, DiskPressure or the problems reported by the node problem detector being True
+func (k *nodeKiller) getUnhealthyCondition(now time.Time, node v1.Node) (unhealthyCondition v1.NodeCondition, unhealthy bool) {
+	policy := k.getNodePolicy(node)
+	if !policy.KillUnhealthy {
+		return
+	}
+
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == v1.NodeReady && condition.Status == v1.ConditionTrue {
+			continue
+		}
+		if condition.Type != v1.NodeReady && condition.Status != v1.ConditionTrue {
+			continue
+		}
+		if now.Sub(condition.LastTransitionTime.Time) < policy.UnhealthyGracePeriod {
+			continue
+		}
+
+		return condition, true
+	}
 
 	return
 }
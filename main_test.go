@@ -2,16 +2,52 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
+	"github.com/alecthomas/kingpin"
 	gomock "github.com/golang/mock/gomock"
 	"github.com/rs/zerolog"
 )
 
+func TestMain(m *testing.M) {
+	// apply the default values of the flags
+	if _, err := kingpin.CommandLine.Parse([]string{}); err != nil {
+		panic(err)
+	}
+
+	os.Exit(m.Run())
+}
+
+// newTestPolicy returns the default policy of the command line flags
+func newTestPolicy() Policy {
+	policy, err := newDefaultPolicy()
+	if err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+// newTestNodeKiller returns a nodeKiller with the default policy for a mocked cluster
+func newTestNodeKiller(client KubernetesClient, clock Clock) *nodeKiller {
+	return &nodeKiller{
+		kubernetesClient: client,
+		clock:            clock,
+		random:           NewRandom(0),
+		filters:          NodeFilter{},
+		interval:         600,
+		policy:           newTestPolicy(),
+		killsThisRun:     map[string]int{},
+	}
+}
+
 func TestGetCurrentNodeState(t *testing.T) {
 	zerolog.SetGlobalLevel(zerolog.Disabled)
 
@@ -54,8 +90,8 @@ func TestGetDesiredNodeState_BetweenTwelveAndTwentyFour(t *testing.T) {
 	client := NewMockKubernetesClient(ctrl)
 	client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "estafette.io/gke-preemptible-killer-state", gomock.Any()).AnyTimes()
 
-	whitelistInstance.parseArguments()
-	state, _ := getDesiredNodeState(now, ctx, client, node)
+	killer := newTestNodeKiller(client, newFakeClock(now))
+	state, _ := killer.getDesiredNodeState(ctx, now, node)
 	stateTS, _ := time.Parse(time.RFC3339, state.ExpiryDatetime)
 
 	if stateTS.Before(now) && !stateTS.Before(certainlyDeadBy) && !stateTS.After(twelveAfterCreation) {
@@ -64,7 +100,7 @@ func TestGetDesiredNodeState_BetweenTwelveAndTwentyFour(t *testing.T) {
 
 	now = creationTimestamp.Add(20 * time.Hour)
 
-	state, _ = getDesiredNodeState(now, ctx, client, node)
+	state, _ = killer.getDesiredNodeState(ctx, now, node)
 	stateTS, _ = time.Parse(time.RFC3339, state.ExpiryDatetime)
 
 	if stateTS.Before(now) && !stateTS.Before(certainlyDeadBy) {
@@ -96,11 +132,1184 @@ func TestGetDesiredNodeState_NotBeforeNow(t *testing.T) {
 	client := NewMockKubernetesClient(ctrl)
 	client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "estafette.io/gke-preemptible-killer-state", gomock.Any()).AnyTimes()
 
-	whitelistInstance.parseArguments()
-	state, _ := getDesiredNodeState(now, ctx, client, node)
+	killer := newTestNodeKiller(client, newFakeClock(now))
+	state, _ := killer.getDesiredNodeState(ctx, now, node)
 	stateTS, _ := time.Parse(time.RFC3339, state.ExpiryDatetime)
 
 	if stateTS.Before(now) && !stateTS.Before(certainlyDeadBy) {
 		t.Errorf("Expect expiry date time should not be before now %s, instead got %s", creationTimestamp, state.ExpiryDatetime)
 	}
 }
+
+func TestPlanSpreadExpiryDate(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	now := time.Date(2017, 11, 11, 12, 00, 00, 0, time.UTC)
+	existingExpiryDates := []time.Time{
+		now.Add(12 * time.Hour),
+		now.Add(24 * time.Hour),
+	}
+
+	expiryDatetime := planSpreadExpiryDate(now, newTestPolicy(), 12*time.Hour, 24*time.Hour, existingExpiryDates)
+
+	if !expiryDatetime.Equal(now.Add(18 * time.Hour)) {
+		t.Errorf("Expect expiry date time to be in the middle of the existing expiry dates %s, instead got %s", now.Add(18*time.Hour), expiryDatetime)
+	}
+}
+
+func TestGetDesiredNodeState_SpreadPlanning(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	creationTimestamp := time.Date(2017, 11, 11, 12, 00, 00, 0, time.UTC)
+	now := creationTimestamp
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node-1",
+			CreationTimestamp: metav1.Time{Time: creationTimestamp},
+			Labels: map[string]string{
+				"cloud.google.com/gke-nodepool": "pool-1",
+			},
+		},
+	}
+	otherNode := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-2",
+			Annotations: map[string]string{
+				"estafette.io/gke-preemptible-killer-state": "2017-11-12T00:00:00Z",
+			},
+		},
+	}
+
+	client := NewMockKubernetesClient(ctrl)
+	client.EXPECT().GetPreemptibleNodes(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, filter NodeFilter) (*v1.NodeList, error) {
+		if filter.String() != "cloud.google.com/gke-nodepool=pool-1" {
+			t.Errorf("Expect nodes of pool-1 to be listed, instead got filter %v", filter)
+		}
+		return &v1.NodeList{Items: []v1.Node{node, otherNode}}, nil
+	})
+	client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "estafette.io/gke-preemptible-killer-state", gomock.Any())
+
+	*expiryPlanning = "spread"
+	defer func() { *expiryPlanning = "random" }()
+
+	killer := newTestNodeKiller(client, newFakeClock(now))
+	state, _ := killer.getDesiredNodeState(ctx, now, node)
+	stateTS, _ := time.Parse(time.RFC3339, state.ExpiryDatetime)
+
+	// the latest possible kick off is furthest away from the other node's expiry
+	latestKickOff := creationTimestamp.Add(24 * time.Hour).Add(-time.Duration(killer.policy.DrainTimeout) * time.Second)
+	if stateTS.Before(latestKickOff.Add(-spreadPlanningStep)) || stateTS.After(latestKickOff) {
+		t.Errorf("Expect expiry date time to be close to %s, instead got %s", latestKickOff, state.ExpiryDatetime)
+	}
+}
+
+func TestGetDesiredNodeState_NodeNameSeed(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	creationTimestamp := time.Date(2017, 11, 11, 12, 00, 00, 0, time.UTC)
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node-1",
+			CreationTimestamp: metav1.Time{Time: creationTimestamp},
+		},
+	}
+
+	client := NewMockKubernetesClient(ctrl)
+	client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "estafette.io/gke-preemptible-killer-state", gomock.Any()).Times(2)
+
+	*nodeNameSeed = true
+	defer func() { *nodeNameSeed = false }()
+
+	killer := newTestNodeKiller(client, newFakeClock(creationTimestamp))
+	state, _ := killer.getDesiredNodeState(ctx, creationTimestamp.Add(1*time.Hour), node)
+	killer.random = NewRandom(2)
+	reannotatedState, _ := killer.getDesiredNodeState(ctx, creationTimestamp.Add(3*time.Hour), node)
+
+	if state.ExpiryDatetime != reannotatedState.ExpiryDatetime {
+		t.Errorf("Expect annotating the node again to result in expiry date time %s, instead got %s", state.ExpiryDatetime, reannotatedState.ExpiryDatetime)
+	}
+}
+
+func TestProcessNode_Lifecycle(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	creationTimestamp := time.Date(2017, 11, 11, 12, 00, 00, 0, time.UTC)
+	clock := newFakeClock(creationTimestamp.Add(1 * time.Minute))
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node-1",
+			CreationTimestamp: metav1.Time{Time: creationTimestamp},
+			Annotations:       map[string]string{},
+		},
+	}
+
+	var deletedAt time.Time
+
+	client := NewMockKubernetesClient(ctrl)
+	client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "estafette.io/gke-preemptible-killer-state", gomock.Any()).
+		DoAndReturn(func(ctx context.Context, nodeName, key, value string) error {
+			node.ObjectMeta.Annotations[key] = value
+			return nil
+		})
+	client.EXPECT().GetUnsafePods(gomock.Any(), "node-1", gomock.Any())
+	client.EXPECT().GetBlockedPods(gomock.Any(), "node-1", gomock.Any())
+	client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "cluster-autoscaler.kubernetes.io/scale-down-disabled", "true")
+	client.EXPECT().SetUnschedulableState(gomock.Any(), "node-1", true)
+	client.EXPECT().GetProjectIdAndZoneFromNode(gomock.Any(), "node-1").Return("project-1", "europe-west1-b", nil)
+	client.EXPECT().DrainNode(gomock.Any(), "node-1", gomock.Any(), gomock.Any()).Return(DrainResult{Outcome: DrainOutcomeDrained}, nil)
+	client.EXPECT().CreateNodeEvent(gomock.Any(), gomock.Any(), v1.EventTypeNormal, "Drained", gomock.Any())
+	client.EXPECT().DeleteNode(gomock.Any(), "node-1").
+		DoAndReturn(func(ctx context.Context, nodeName string) error {
+			deletedAt = clock.Now()
+			return nil
+		})
+
+	gcloud := NewMockGCloudClient(ctrl)
+	gcloud.EXPECT().DeleteNode("node-1")
+
+	newGCloudClient = func(projectID string, zone string) (GCloudClient, error) {
+		return gcloud, nil
+	}
+	defer func() { newGCloudClient = NewGCloudClient }()
+
+	killer := newTestNodeKiller(client, clock)
+
+	// run the processing loop every 10 minutes of simulated time for the whole life of the node
+	for deletedAt.IsZero() && clock.Now().Before(creationTimestamp.Add(25*time.Hour)) {
+		err := killer.processNode(ctx, node)
+		if err != nil {
+			t.Fatalf("Expect processing node to succeed, instead got %v", err)
+		}
+		clock.Advance(10 * time.Minute)
+	}
+
+	if deletedAt.Before(creationTimestamp.Add(12*time.Hour)) || deletedAt.After(creationTimestamp.Add(24*time.Hour)) {
+		t.Errorf("Expect node to be deleted between 12 and 24h after the creation date %s, instead got %s", creationTimestamp, deletedAt)
+	}
+}
+
+func TestProcessNode_KillBudget(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	now := time.Date(2017, 11, 12, 12, 00, 00, 0, time.UTC)
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+			Annotations: map[string]string{
+				"estafette.io/gke-preemptible-killer-state": "2017-11-12T11:00:00Z",
+			},
+		},
+	}
+
+	// no calls to the client are expected as the budget is used up
+	client := NewMockKubernetesClient(ctrl)
+
+	killer := newTestNodeKiller(client, newFakeClock(now))
+	killer.policy.KillBudget = 1
+	killer.killsThisRun[killer.policy.getKey()] = 1
+
+	err := killer.processNode(ctx, node)
+
+	if err != nil {
+		t.Errorf("Expect postponing node to succeed, instead got %v", err)
+	}
+}
+
+func TestProcessNode_DisabledPolicy(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	now := time.Date(2017, 11, 12, 12, 00, 00, 0, time.UTC)
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+			Labels: map[string]string{
+				"cloud.google.com/gke-nodepool": "batch",
+			},
+			Annotations: map[string]string{
+				"estafette.io/gke-preemptible-killer-state": "2017-11-12T11:00:00Z",
+			},
+		},
+	}
+
+	// no calls to the client are expected as the policy of the node pool is disabled
+	client := NewMockKubernetesClient(ctrl)
+
+	enabled := false
+	killer := newTestNodeKiller(client, newFakeClock(now))
+	policy, err := PolicyConfig{Name: "batch", NodePool: "batch", PolicySettings: PolicySettings{Enabled: &enabled}}.getPolicy(killer.policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	killer.policies = []Policy{policy}
+
+	err = killer.processNode(ctx, node)
+
+	if err != nil {
+		t.Errorf("Expect skipping node to succeed, instead got %v", err)
+	}
+}
+
+func TestKillPolicyStatuses(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	now := time.Date(2017, 11, 12, 12, 00, 00, 0, time.UTC)
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+			Labels: map[string]string{
+				"cloud.google.com/gke-nodepool": "batch",
+			},
+			Annotations: map[string]string{
+				"estafette.io/gke-preemptible-killer-state": "2017-11-12T15:00:00Z",
+			},
+		},
+	}
+
+	killPolicies := []PreemptibleKillPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "batch"},
+			Spec:       PreemptibleKillPolicySpec{NodePool: "batch"},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "invalid"},
+			Spec:       PreemptibleKillPolicySpec{Selector: "team in payments"},
+		},
+	}
+
+	client := NewMockKubernetesClient(ctrl)
+	client.EXPECT().GetKillPolicies(ctx).Return(killPolicies, nil)
+	client.EXPECT().UpdateKillPolicyStatus(ctx, gomock.Any()).DoAndReturn(func(ctx context.Context, killPolicy PreemptibleKillPolicy) error {
+		switch killPolicy.ObjectMeta.Name {
+		case "batch":
+			if killPolicy.Status.NodesManaged != 1 || !killPolicy.Status.NextKill.Time.Equal(time.Date(2017, 11, 12, 15, 00, 00, 0, time.UTC)) {
+				t.Errorf("Expect batch policy to manage 1 node killed at 15:00, instead got %v", killPolicy.Status)
+			}
+		case "invalid":
+			if killPolicy.Status.NodesManaged != 0 || killPolicy.Status.LastError == "" {
+				t.Errorf("Expect invalid policy to report its error, instead got %v", killPolicy.Status)
+			}
+		}
+		return nil
+	}).Times(2)
+
+	killer := newTestNodeKiller(client, newFakeClock(now))
+	killer.loadKillPolicies(ctx)
+
+	if len(killer.policies) != 1 || killer.getNodePolicy(node).Name != "batch" {
+		t.Fatalf("Expect only the valid kill policy to apply, instead got %v", killer.policies)
+	}
+
+	err := killer.processNode(ctx, node)
+	killer.recordKillPolicyNode(killer.getNodePolicy(node), err)
+
+	killer.updateKillPolicyStatuses(ctx)
+}
+
+func TestReloadConfig(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctx := context.Background()
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(config string) {
+		if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	killer := newTestNodeKiller(nil, newFakeClock(time.Now()))
+	killer.configFile = configPath
+	killer.defaultPolicy = killer.policy
+
+	writeConfig("filters: \"cloud.google.com/gke-nodepool: preemptible\"\ninterval: 60\nwhitelistHours: \"09:00 - 12:00\"\ndrainTimeout: 900\n")
+	killer.reloadConfig(ctx)
+
+	if killer.filters.String() != "cloud.google.com/gke-nodepool in (preemptible)" || killer.interval != 60 {
+		t.Errorf("Expected filters and interval to be reloaded, got %v and %v", killer.filters, killer.interval)
+	}
+	if killer.policy.DrainTimeout != 900 || killer.policy.Whitelist.whitelistSecondCount != 3*3600 {
+		t.Errorf("Expected drain timeout and whitelist to be reloaded, got %v", killer.policy)
+	}
+
+	// an invalid configuration keeps the current settings
+	writeConfig("whitelistHours: \"09:00 - 25:00\"\n")
+	killer.reloadConfig(ctx)
+
+	if killer.policy.DrainTimeout != 900 || killer.policy.Whitelist.whitelistSecondCount != 3*3600 {
+		t.Errorf("Expected settings to be kept on invalid configuration, got %v", killer.policy)
+	}
+
+	// a configuration without the cluster keeps the current settings
+	writeConfig("clusters:\n- name: production\n")
+	killer.reloadConfig(ctx)
+
+	if killer.interval != 60 {
+		t.Errorf("Expected settings to be kept when the cluster is missing, got interval %v", killer.interval)
+	}
+}
+
+func TestReloadConfig_InvalidSettings(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctx := context.Background()
+
+	configPath := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(config string) {
+		if err := os.WriteFile(configPath, []byte(config), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	killer := newTestNodeKiller(nil, newFakeClock(time.Now()))
+	killer.cluster = "production"
+	killer.configFile = configPath
+	killer.defaultPolicy = killer.policy
+
+	writeConfig("clusters:\n- name: production\n  interval: 60\n  expiryPlanning: spread\n")
+	killer.reloadConfig(ctx)
+
+	if killer.interval != 60 || killer.policy.ExpiryPlanning != "spread" {
+		t.Fatalf("Expected interval and expiry planning to be reloaded, got %v and %v", killer.interval, killer.policy.ExpiryPlanning)
+	}
+
+	invalidConfigs := []string{
+		"interval: 0\nclusters:\n- name: production\n",
+		"clusters:\n- name: production\n  interval: 0\n",
+		"clusters:\n- name: production\n  expiryPlanning: evenly\n",
+		"expiryPlanning: evenly\nclusters:\n- name: production\n",
+		"clusters:\n- name: production\n  drainTimeout: -1\n",
+		"clusters:\n- name: production\n  blacklistHours: 00:00 - 12:00, 12:00 - 00:00\n",
+	}
+	for _, config := range invalidConfigs {
+		writeConfig(config)
+		killer.reloadConfig(ctx)
+
+		if killer.interval != 60 || killer.policy.ExpiryPlanning != "spread" {
+			t.Errorf("Expected settings to be kept on invalid configuration %q, got interval %v and expiry planning %v", config, killer.interval, killer.policy.ExpiryPlanning)
+		}
+	}
+}
+
+func TestGetUnhealthyCondition(t *testing.T) {
+	now := time.Date(2017, 11, 12, 12, 00, 00, 0, time.UTC)
+
+	newNode := func(conditions ...v1.NodeCondition) v1.Node {
+		return v1.Node{Status: v1.NodeStatus{Conditions: conditions}}
+	}
+	condition := func(conditionType v1.NodeConditionType, status v1.ConditionStatus, since time.Duration) v1.NodeCondition {
+		return v1.NodeCondition{Type: conditionType, Status: status, LastTransitionTime: metav1.Time{Time: now.Add(-since)}}
+	}
+
+	nodes := map[string]struct {
+		node      v1.Node
+		unhealthy bool
+	}{
+		"ready":                {newNode(condition(v1.NodeReady, v1.ConditionTrue, time.Hour), condition(v1.NodeMemoryPressure, v1.ConditionFalse, time.Hour)), false},
+		"not ready":            {newNode(condition(v1.NodeReady, v1.ConditionFalse, time.Hour)), true},
+		"unknown":              {newNode(condition(v1.NodeReady, v1.ConditionUnknown, time.Hour)), true},
+		"not ready just now":   {newNode(condition(v1.NodeReady, v1.ConditionFalse, time.Minute)), false},
+		"disk pressure":        {newNode(condition(v1.NodeReady, v1.ConditionTrue, time.Hour), condition(v1.NodeDiskPressure, v1.ConditionTrue, time.Hour)), true},
+		"kubelet problem":      {newNode(condition(v1.NodeReady, v1.ConditionTrue, time.Hour), condition("FrequentKubeletRestart", v1.ConditionTrue, time.Hour)), true},
+		"network not reported": {newNode(condition(v1.NodeReady, v1.ConditionTrue, time.Hour), condition(v1.NodeNetworkUnavailable, v1.ConditionFalse, time.Hour)), false},
+	}
+
+	killer := newTestNodeKiller(nil, newFakeClock(now))
+	killer.policy.KillUnhealthy = true
+
+	for description, n := range nodes {
+		_, unhealthy := killer.getUnhealthyCondition(now, n.node)
+		if unhealthy != n.unhealthy {
+			t.Errorf("Expected node %v to be unhealthy: %v", description, n.unhealthy)
+		}
+	}
+
+	killer.policy.KillUnhealthy = false
+	if _, unhealthy := killer.getUnhealthyCondition(now, nodes["not ready"].node); unhealthy {
+		t.Errorf("Expected unhealthy nodes to be ignored when the policy doesn't kill them")
+	}
+}
+
+func TestProcessNode_Unhealthy(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	now := time.Date(2017, 11, 12, 12, 00, 00, 0, time.UTC)
+
+	// the node isn't expired, but hasn't been ready for an hour
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+			Annotations: map[string]string{
+				"estafette.io/gke-preemptible-killer-state": "2017-11-12T20:00:00Z",
+			},
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{
+				{Type: v1.NodeReady, Status: v1.ConditionFalse, LastTransitionTime: metav1.Time{Time: now.Add(-time.Hour)}},
+			},
+		},
+	}
+
+	client := NewMockKubernetesClient(ctrl)
+	client.EXPECT().GetUnsafePods(gomock.Any(), "node-1", gomock.Any())
+	client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "cluster-autoscaler.kubernetes.io/scale-down-disabled", "true")
+	client.EXPECT().SetUnschedulableState(gomock.Any(), "node-1", true)
+	client.EXPECT().GetProjectIdAndZoneFromNode(gomock.Any(), "node-1").Return("project-1", "europe-west1-b", nil)
+	client.EXPECT().DrainNode(gomock.Any(), "node-1", gomock.Any(), gomock.Any()).Return(DrainResult{Outcome: DrainOutcomeDrained}, nil)
+	client.EXPECT().CreateNodeEvent(gomock.Any(), gomock.Any(), v1.EventTypeNormal, "Drained", gomock.Any())
+	client.EXPECT().DeleteNode(gomock.Any(), "node-1")
+
+	gcloud := NewMockGCloudClient(ctrl)
+	gcloud.EXPECT().DeleteNode("node-1")
+
+	newGCloudClient = func(projectID string, zone string) (GCloudClient, error) {
+		return gcloud, nil
+	}
+	defer func() { newGCloudClient = NewGCloudClient }()
+
+	killer := newTestNodeKiller(client, newFakeClock(now))
+	killer.policy.KillUnhealthy = true
+
+	err := killer.processNode(ctx, node)
+
+	if err != nil {
+		t.Errorf("Expect killing unhealthy node to succeed, instead got %v", err)
+	}
+}
+
+func TestProcessNode_MinimumReadyNodes(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	now := time.Date(2017, 11, 12, 12, 00, 00, 0, time.UTC)
+
+	newNode := func(name string, ready v1.ConditionStatus) v1.Node {
+		return v1.Node{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              name,
+				CreationTimestamp: metav1.Time{Time: now.Add(-13 * time.Hour)},
+				Labels: map[string]string{
+					"cloud.google.com/gke-nodepool": "pool-1",
+				},
+				Annotations: map[string]string{
+					"estafette.io/gke-preemptible-killer-state": "2017-11-12T11:00:00Z",
+				},
+			},
+			Status: v1.NodeStatus{
+				Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: ready}},
+			},
+		}
+	}
+	node := newNode("node-1", v1.ConditionTrue)
+
+	// killing the expired node would leave a single ready node in the pool
+	client := NewMockKubernetesClient(ctrl)
+	client.EXPECT().GetPreemptibleNodes(gomock.Any(), gomock.Any()).
+		Return(&v1.NodeList{Items: []v1.Node{node, newNode("node-2", v1.ConditionTrue), newNode("node-3", v1.ConditionFalse)}}, nil)
+	client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "estafette.io/gke-preemptible-killer-state", "2017-11-12T12:30:00Z")
+
+	killer := newTestNodeKiller(client, newFakeClock(now))
+	killer.policy.MinimumReadyNodes = 2
+
+	err := killer.processNode(ctx, node)
+
+	if err != nil {
+		t.Errorf("Expect postponing node to succeed, instead got %v", err)
+	}
+	if killer.killsThisRun[killer.policy.getKey()] != 0 {
+		t.Errorf("Expect postponed node not to use the kill budget, instead got %d kills", killer.killsThisRun[killer.policy.getKey()])
+	}
+}
+
+func TestProcessNode_NoLifetimeLeft(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	now := time.Date(2017, 11, 12, 12, 00, 00, 0, time.UTC)
+
+	killer := newTestNodeKiller(nil, newFakeClock(now))
+	killer.policy.MinimumReadyNodes = 2
+	killer.policy.BarePods = "postpone"
+
+	// the latest kick off within the maximum lifetime has passed, so the node is killed although the pool is at its
+	// minimum of ready nodes, a budget allows no disruption of its pods and it has a bare pod
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node-1",
+			CreationTimestamp: metav1.Time{Time: now.Add(-killer.policy.MaximumLifetime).Add(killer.policy.getMaximumDrainDuration()).Add(-time.Minute)},
+			Labels: map[string]string{
+				"cloud.google.com/gke-nodepool": "pool-1",
+			},
+			Annotations: map[string]string{
+				"estafette.io/gke-preemptible-killer-state": "2017-11-12T11:30:00Z",
+			},
+		},
+		Status: v1.NodeStatus{
+			Conditions: []v1.NodeCondition{{Type: v1.NodeReady, Status: v1.ConditionTrue}},
+		},
+	}
+
+	proceedWithBarePods := func(ctx context.Context, nodeName string, options DrainOptions) (DrainResult, error) {
+		if options.BarePods != "proceed" {
+			t.Errorf("Expect bare pods to be evicted once the kill can't be postponed, instead got action %v", options.BarePods)
+		}
+		return DrainResult{BarePods: []string{"default/debug"}}, nil
+	}
+
+	client := NewMockKubernetesClient(ctrl)
+	client.EXPECT().GetPreemptibleNodes(gomock.Any(), gomock.Any()).Times(0)
+	client.EXPECT().GetBlockedPods(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	client.EXPECT().GetUnsafePods(gomock.Any(), "node-1", gomock.Any()).DoAndReturn(proceedWithBarePods)
+	client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "cluster-autoscaler.kubernetes.io/scale-down-disabled", "true")
+	client.EXPECT().SetUnschedulableState(gomock.Any(), "node-1", true)
+	client.EXPECT().GetProjectIdAndZoneFromNode(gomock.Any(), "node-1").Return("project-1", "europe-west1-b", nil)
+	client.EXPECT().DrainNode(gomock.Any(), "node-1", gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, nodeName string, drainTimeout int, options DrainOptions) (DrainResult, error) {
+			result, err := proceedWithBarePods(ctx, nodeName, options)
+			result.Outcome = DrainOutcomeDrained
+			return result, err
+		})
+	client.EXPECT().CreateNodeEvent(gomock.Any(), gomock.Any(), v1.EventTypeNormal, "Drained", gomock.Any())
+	client.EXPECT().DeleteNode(gomock.Any(), "node-1")
+	killer.kubernetesClient = client
+
+	gcloud := NewMockGCloudClient(ctrl)
+	gcloud.EXPECT().DeleteNode("node-1")
+
+	newGCloudClient = func(projectID string, zone string) (GCloudClient, error) {
+		return gcloud, nil
+	}
+	defer func() { newGCloudClient = NewGCloudClient }()
+
+	err := killer.processNode(ctx, node)
+
+	if err != nil {
+		t.Errorf("Expect killing node to succeed, instead got %v", err)
+	}
+}
+
+func TestGetExpiryOffsetBounds_MaximumDrainDuration(t *testing.T) {
+	now := time.Date(2017, 11, 12, 12, 00, 00, 0, time.UTC)
+	policy := newTestPolicy()
+	policy.DrainTimeout = 300
+	policy.MaximumGracePeriod = 10 * time.Minute
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			CreationTimestamp: metav1.Time{Time: now},
+		},
+	}
+
+	// pods can keep the drain going up to the maximum grace period, longer than the drain timeout
+	_, maximumOffset := getExpiryOffsetBounds(now, policy, node)
+	if maximumOffset != policy.MaximumLifetime-10*time.Minute {
+		t.Errorf("Expect the maximum grace period to be reserved to drain the node, instead got maximum offset %v", maximumOffset)
+	}
+
+	// deleting the pods left at the timeout adds their grace period
+	policy.DrainTimeoutAction = "delete"
+	policy.DeleteGracePeriod = time.Minute
+	if drainDuration := policy.getMaximumDrainDuration(); drainDuration != 11*time.Minute {
+		t.Errorf("Expect maximum drain duration of 11m0s, instead got %v", drainDuration)
+	}
+}
+
+func TestGetPostponedExpiryDate(t *testing.T) {
+	now := time.Date(2017, 11, 12, 12, 00, 00, 0, time.UTC)
+	policy := newTestPolicy()
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			CreationTimestamp: metav1.Time{Time: now.Add(-13 * time.Hour)},
+		},
+	}
+
+	expiryDatetime := getPostponedExpiryDate(now, policy, node)
+	if !expiryDatetime.Equal(now.Add(postponeDelay)) {
+		t.Errorf("Expect expiry to be postponed by %v, instead got %v", postponeDelay, expiryDatetime)
+	}
+
+	// the latest kick off within the maximum lifetime is sooner than the postpone delay
+	node.ObjectMeta.CreationTimestamp = metav1.Time{Time: now.Add(-policy.MaximumLifetime).Add(20 * time.Minute)}
+	latestKickOff := now.Add(20 * time.Minute).Add(-policy.getMaximumDrainDuration())
+
+	expiryDatetime = getPostponedExpiryDate(now, policy, node)
+	if !expiryDatetime.Equal(latestKickOff) {
+		t.Errorf("Expect expiry to be postponed to the latest kick off %v, instead got %v", latestKickOff, expiryDatetime)
+	}
+
+	// the maximum lifetime has already run out, which only happens after an aborted drain since the kill isn't
+	// postponed anymore once the latest kick off has passed
+	node.ObjectMeta.CreationTimestamp = metav1.Time{Time: now.Add(-policy.MaximumLifetime).Add(-time.Hour)}
+
+	expiryDatetime = getPostponedExpiryDate(now, policy, node)
+	if !expiryDatetime.Equal(now.Add(postponeDelay)) {
+		t.Errorf("Expect expiry to be postponed by %v once the lifetime ran out, instead got %v", postponeDelay, expiryDatetime)
+	}
+}
+
+func TestProcessNode_ClusterAutoscaler(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	now := time.Date(2017, 11, 12, 12, 00, 00, 0, time.UTC)
+
+	// no calls to the client are expected as the autoscaler is removing the expired node
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+			Annotations: map[string]string{
+				"estafette.io/gke-preemptible-killer-state": "2017-11-12T11:00:00Z",
+			},
+		},
+		Spec: v1.NodeSpec{
+			Taints: []v1.Taint{{Key: "ToBeDeletedByClusterAutoscaler", Effect: v1.TaintEffectNoSchedule}},
+		},
+	}
+
+	client := NewMockKubernetesClient(ctrl)
+
+	killer := newTestNodeKiller(client, newFakeClock(now))
+
+	err := killer.processNode(ctx, node)
+
+	if err != nil {
+		t.Errorf("Expect skipping node to succeed, instead got %v", err)
+	}
+}
+
+func TestUpdateScaleDownProtection(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	creationTimestamp := time.Date(2017, 11, 12, 12, 00, 00, 0, time.UTC)
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node-1",
+			CreationTimestamp: metav1.Time{Time: creationTimestamp},
+			Annotations:       map[string]string{},
+		},
+	}
+
+	client := NewMockKubernetesClient(ctrl)
+	client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", gomock.Any(), gomock.Any()).
+		DoAndReturn(func(ctx context.Context, nodeName, key, value string) error {
+			node.ObjectMeta.Annotations[key] = value
+			return nil
+		}).Times(2)
+	client.EXPECT().RemoveNodeAnnotation(gomock.Any(), "node-1", gomock.Any()).
+		DoAndReturn(func(ctx context.Context, nodeName, key string) error {
+			delete(node.ObjectMeta.Annotations, key)
+			return nil
+		}).Times(2)
+
+	killer := newTestNodeKiller(client, newFakeClock(creationTimestamp))
+	killer.policy.ScaleDownGracePeriod = time.Hour
+
+	// a new node is protected once during the grace period
+	killer.updateScaleDownProtection(ctx, creationTimestamp.Add(time.Minute), node, killer.policy)
+	killer.updateScaleDownProtection(ctx, creationTimestamp.Add(10*time.Minute), node, killer.policy)
+
+	if node.ObjectMeta.Annotations["cluster-autoscaler.kubernetes.io/scale-down-disabled"] != "true" || node.ObjectMeta.Annotations["estafette.io/gke-preemptible-killer-scale-down-disabled-until"] != "2017-11-12T13:00:00Z" {
+		t.Errorf("Expect scale down to be disabled until 13:00, instead got annotations %v", node.ObjectMeta.Annotations)
+	}
+
+	// the protection is lifted after the grace period
+	killer.updateScaleDownProtection(ctx, creationTimestamp.Add(2*time.Hour), node, killer.policy)
+
+	if len(node.ObjectMeta.Annotations) != 0 {
+		t.Errorf("Expect scale down to be enabled again, instead got annotations %v", node.ObjectMeta.Annotations)
+	}
+
+	// scale down disabled by someone else is left alone
+	node.ObjectMeta.Annotations["cluster-autoscaler.kubernetes.io/scale-down-disabled"] = "true"
+	killer.updateScaleDownProtection(ctx, creationTimestamp.Add(3*time.Hour), node, killer.policy)
+
+	if node.ObjectMeta.Annotations["cluster-autoscaler.kubernetes.io/scale-down-disabled"] != "true" {
+		t.Errorf("Expect scale down disabled by someone else to be kept, instead got annotations %v", node.ObjectMeta.Annotations)
+	}
+}
+
+func TestKillNode_DrainAborted(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	now := time.Date(2017, 11, 12, 12, 00, 00, 0, time.UTC)
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node-1",
+			CreationTimestamp: metav1.Time{Time: now.Add(-13 * time.Hour)},
+			Annotations: map[string]string{
+				"estafette.io/gke-preemptible-killer-state": "2017-11-12T11:00:00Z",
+			},
+		},
+	}
+
+	// the drain times out and the policy aborts the kill, so the node is uncordoned and postponed
+	client := NewMockKubernetesClient(ctrl)
+	gomock.InOrder(
+		client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "cluster-autoscaler.kubernetes.io/scale-down-disabled", "true"),
+		client.EXPECT().SetUnschedulableState(gomock.Any(), "node-1", true),
+		client.EXPECT().GetProjectIdAndZoneFromNode(gomock.Any(), "node-1").Return("project-1", "europe-west1-b", nil),
+		client.EXPECT().DrainNode(gomock.Any(), "node-1", gomock.Any(), gomock.Any()).
+			DoAndReturn(func(ctx context.Context, nodeName string, drainTimeout int, options DrainOptions) (DrainResult, error) {
+				if options.TimeoutAction != "abort" {
+					t.Errorf("Expect timeout action abort of the policy, instead got %v", options.TimeoutAction)
+				}
+				return DrainResult{Outcome: DrainOutcomeAborted, RemainingPods: []string{"default/pod-1"}}, nil
+			}),
+		client.EXPECT().CreateNodeEvent(gomock.Any(), gomock.Any(), v1.EventTypeWarning, "DrainTimeout", "Drain timeout reached with 1 pod(s) remaining (default/pod-1), aborting the kill"),
+		client.EXPECT().SetUnschedulableState(gomock.Any(), "node-1", false),
+		client.EXPECT().RemoveNodeAnnotation(gomock.Any(), "node-1", "cluster-autoscaler.kubernetes.io/scale-down-disabled"),
+		client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "estafette.io/gke-preemptible-killer-state", "2017-11-12T12:30:00Z"),
+	)
+
+	newGCloudClient = func(projectID string, zone string) (GCloudClient, error) {
+		return NewMockGCloudClient(ctrl), nil
+	}
+	defer func() { newGCloudClient = NewGCloudClient }()
+
+	killer := newTestNodeKiller(client, newFakeClock(now))
+	killer.policy.DrainTimeoutAction = "abort"
+
+	killed, err := killer.killNode(ctx, node, killer.policy, true)
+
+	if err != nil {
+		t.Errorf("Expect aborting the kill to succeed, instead got %v", err)
+	}
+	if killed {
+		t.Errorf("Expect node not to be killed")
+	}
+}
+
+func TestProcessNode_DrainAbortedNoLifetimeLeft(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	now := time.Date(2017, 11, 12, 12, 00, 00, 0, time.UTC)
+
+	killer := newTestNodeKiller(nil, newFakeClock(now))
+	killer.policy.DrainTimeoutAction = "abort"
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node-1",
+			CreationTimestamp: metav1.Time{Time: now.Add(-killer.policy.MaximumLifetime).Add(time.Minute)},
+			Annotations: map[string]string{
+				"estafette.io/gke-preemptible-killer-state": "2017-11-12T11:30:00Z",
+			},
+		},
+	}
+
+	// the drain times out and the policy aborts the kill, but the node has no lifetime left to postpone it, so it's
+	// deleted with the pods still on it
+	client := NewMockKubernetesClient(ctrl)
+	gomock.InOrder(
+		client.EXPECT().GetUnsafePods(gomock.Any(), "node-1", gomock.Any()),
+		client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "cluster-autoscaler.kubernetes.io/scale-down-disabled", "true"),
+		client.EXPECT().SetUnschedulableState(gomock.Any(), "node-1", true),
+		client.EXPECT().GetProjectIdAndZoneFromNode(gomock.Any(), "node-1").Return("project-1", "europe-west1-b", nil),
+		client.EXPECT().DrainNode(gomock.Any(), "node-1", gomock.Any(), gomock.Any()).
+			Return(DrainResult{Outcome: DrainOutcomeAborted, RemainingPods: []string{"default/pod-1"}}, nil),
+		client.EXPECT().CreateNodeEvent(gomock.Any(), gomock.Any(), v1.EventTypeWarning, "DrainTimeout", gomock.Any()),
+		client.EXPECT().DeleteNode(gomock.Any(), "node-1"),
+	)
+	killer.kubernetesClient = client
+
+	gcloud := NewMockGCloudClient(ctrl)
+	gcloud.EXPECT().DeleteNode("node-1")
+
+	newGCloudClient = func(projectID string, zone string) (GCloudClient, error) {
+		return gcloud, nil
+	}
+	defer func() { newGCloudClient = NewGCloudClient }()
+
+	err := killer.processNode(ctx, node)
+
+	if err != nil {
+		t.Errorf("Expect killing node to succeed, instead got %v", err)
+	}
+}
+
+func TestKillNode_DrainRefused(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+			Annotations: map[string]string{
+				"cluster-autoscaler.kubernetes.io/scale-down-disabled": "true",
+			},
+		},
+	}
+
+	// the node has a bare pod and the policy refuses to kill it, so it's uncordoned without postponing its kill
+	client := NewMockKubernetesClient(ctrl)
+	gomock.InOrder(
+		client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "cluster-autoscaler.kubernetes.io/scale-down-disabled", "true"),
+		client.EXPECT().SetUnschedulableState(gomock.Any(), "node-1", true),
+		client.EXPECT().GetProjectIdAndZoneFromNode(gomock.Any(), "node-1").Return("project-1", "europe-west1-b", nil),
+		client.EXPECT().DrainNode(gomock.Any(), "node-1", gomock.Any(), gomock.Any()).
+			Return(DrainResult{Outcome: DrainOutcomeRefused, BarePods: []string{"default/debug"}}, nil),
+		client.EXPECT().CreateNodeEvent(gomock.Any(), gomock.Any(), v1.EventTypeWarning, "DrainRefused", "Refused to kill the node for pods that would be lost; pod(s) without controller: default/debug"),
+		client.EXPECT().SetUnschedulableState(gomock.Any(), "node-1", false),
+	)
+
+	newGCloudClient = func(projectID string, zone string) (GCloudClient, error) {
+		return NewMockGCloudClient(ctrl), nil
+	}
+	defer func() { newGCloudClient = NewGCloudClient }()
+
+	killer := newTestNodeKiller(client, newFakeClock(time.Now()))
+	killer.policy.BarePods = "refuse"
+
+	killed, err := killer.killNode(ctx, node, killer.policy, true)
+
+	if err != nil {
+		t.Errorf("Expect refusing the kill to succeed, instead got %v", err)
+	}
+	if killed {
+		t.Errorf("Expect node not to be killed")
+	}
+}
+
+func TestProcessNode_PodDisruptionBudget(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	now := time.Date(2017, 11, 12, 12, 00, 00, 0, time.UTC)
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node-1",
+			CreationTimestamp: metav1.Time{Time: now.Add(-13 * time.Hour)},
+			Annotations: map[string]string{
+				"estafette.io/gke-preemptible-killer-state": "2017-11-12T11:00:00Z",
+			},
+		},
+	}
+
+	// the expired node has a pod whose budget allows no disruption, so it's postponed without being cordoned
+	client := NewMockKubernetesClient(ctrl)
+	client.EXPECT().GetUnsafePods(gomock.Any(), "node-1", gomock.Any())
+	client.EXPECT().GetBlockedPods(gomock.Any(), "node-1", gomock.Any()).Return([]string{"default/web-1 (budget web)"}, nil)
+	client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "estafette.io/gke-preemptible-killer-state", "2017-11-12T12:30:00Z")
+
+	killer := newTestNodeKiller(client, newFakeClock(now))
+
+	err := killer.processNode(ctx, node)
+
+	if err != nil {
+		t.Errorf("Expect postponing node to succeed, instead got %v", err)
+	}
+	if killer.killsThisRun[killer.policy.getKey()] != 0 {
+		t.Errorf("Expect postponed node not to use the kill budget, instead got %d kills", killer.killsThisRun[killer.policy.getKey()])
+	}
+}
+
+func TestProcessNode_UnsafePodsRefused(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	now := time.Date(2017, 11, 12, 12, 00, 00, 0, time.UTC)
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "node-1",
+			CreationTimestamp: metav1.Time{Time: now.Add(-13 * time.Hour)},
+			Annotations: map[string]string{
+				"estafette.io/gke-preemptible-killer-state": "2017-11-12T11:00:00Z",
+			},
+		},
+	}
+
+	// the expired node has a bare pod and the policy refuses to kill it, so it's never cordoned nor gets an event,
+	// however many runs
+	client := NewMockKubernetesClient(ctrl)
+	client.EXPECT().GetUnsafePods(gomock.Any(), "node-1", gomock.Any()).
+		Return(DrainResult{Outcome: DrainOutcomeRefused, BarePods: []string{"default/debug"}}, nil).
+		Times(2)
+	client.EXPECT().CreateNodeEvent(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+	client.EXPECT().SetUnschedulableState(gomock.Any(), gomock.Any(), gomock.Any()).Times(0)
+
+	clock := newFakeClock(now)
+	killer := newTestNodeKiller(client, clock)
+	killer.policy.BarePods = "refuse"
+	killer.policy.KillBudget = 1
+
+	for run := 0; run < 2; run++ {
+		killer.killsThisRun = map[string]int{}
+
+		err := killer.processNode(ctx, node)
+
+		if err != nil {
+			t.Errorf("Expect refusing the kill to succeed, instead got %v", err)
+		}
+		if killer.killsThisRun[killer.policy.getKey()] != 0 {
+			t.Errorf("Expect refused node not to use the kill budget, instead got %d kills", killer.killsThisRun[killer.policy.getKey()])
+		}
+
+		clock.Advance(5 * time.Minute)
+	}
+}
+
+func TestKillNode_DrainFailed(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+		},
+	}
+
+	// the pods of the node can't be listed, so the failed drain is recorded and the node is released without being
+	// deleted, to try again at the next run
+	client := NewMockKubernetesClient(ctrl)
+	gomock.InOrder(
+		client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "cluster-autoscaler.kubernetes.io/scale-down-disabled", "true"),
+		client.EXPECT().SetUnschedulableState(gomock.Any(), "node-1", true),
+		client.EXPECT().GetProjectIdAndZoneFromNode(gomock.Any(), "node-1").Return("project-1", "europe-west1-b", nil),
+		client.EXPECT().DrainNode(gomock.Any(), "node-1", gomock.Any(), gomock.Any()).
+			Return(DrainResult{Outcome: DrainOutcomeFailed, Duration: 5 * time.Second}, fmt.Errorf("etcd unavailable")),
+		client.EXPECT().CreateNodeEvent(gomock.Any(), gomock.Any(), v1.EventTypeWarning, "DrainFailed", "Failed to drain the node, 0 pod(s) evicted, 0 already gone, 0 blocked, 0 failed and 0 timed out in 5s"),
+		client.EXPECT().SetUnschedulableState(gomock.Any(), "node-1", false),
+		client.EXPECT().RemoveNodeAnnotation(gomock.Any(), "node-1", "cluster-autoscaler.kubernetes.io/scale-down-disabled"),
+	)
+
+	newGCloudClient = func(projectID string, zone string) (GCloudClient, error) {
+		return NewMockGCloudClient(ctrl), nil
+	}
+	defer func() { newGCloudClient = NewGCloudClient }()
+
+	killer := newTestNodeKiller(client, newFakeClock(time.Now()))
+
+	killed, err := killer.killNode(ctx, node, killer.policy, true)
+
+	if err == nil {
+		t.Errorf("Expect the drain error to be returned")
+	}
+	if killed {
+		t.Errorf("Expect node not to be killed")
+	}
+}
+
+func TestKillNode_DrainFailedNoLifetimeLeft(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	node := v1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: "node-1",
+		},
+	}
+
+	// the drain fails, but the node has no lifetime left to try again later, so it's deleted anyway
+	client := NewMockKubernetesClient(ctrl)
+	gomock.InOrder(
+		client.EXPECT().SetNodeAnnotation(gomock.Any(), "node-1", "cluster-autoscaler.kubernetes.io/scale-down-disabled", "true"),
+		client.EXPECT().SetUnschedulableState(gomock.Any(), "node-1", true),
+		client.EXPECT().GetProjectIdAndZoneFromNode(gomock.Any(), "node-1").Return("project-1", "europe-west1-b", nil),
+		client.EXPECT().DrainNode(gomock.Any(), "node-1", gomock.Any(), gomock.Any()).
+			Return(DrainResult{Outcome: DrainOutcomeFailed}, fmt.Errorf("etcd unavailable")),
+		client.EXPECT().CreateNodeEvent(gomock.Any(), gomock.Any(), v1.EventTypeWarning, "DrainFailed", gomock.Any()),
+		client.EXPECT().DeleteNode(gomock.Any(), "node-1"),
+	)
+
+	gcloud := NewMockGCloudClient(ctrl)
+	gcloud.EXPECT().DeleteNode("node-1")
+
+	newGCloudClient = func(projectID string, zone string) (GCloudClient, error) {
+		return gcloud, nil
+	}
+	defer func() { newGCloudClient = NewGCloudClient }()
+
+	killer := newTestNodeKiller(client, newFakeClock(time.Now()))
+
+	killed, err := killer.killNode(ctx, node, killer.policy, false)
+
+	if err != nil {
+		t.Errorf("Expect node to be deleted despite the drain error, instead got %v", err)
+	}
+	if !killed {
+		t.Errorf("Expect node to be killed")
+	}
+}
+
+func TestNewNodeKiller_ConnectionFailed(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	cluster := ClusterConfig{
+		Name:       "production",
+		Kubeconfig: filepath.Join(t.TempDir(), "missing"),
+		Context:    "production",
+	}
+
+	// a cluster that can't be connected to is kept to retry at the next run instead of failing all clusters
+	killer, err := newNodeKiller(Config{}, cluster, newTestPolicy(), newFakeClock(time.Now()), NewRandom(0))
+
+	if err != nil {
+		t.Fatalf("Expect cluster to be kept, instead got %v", err)
+	}
+	if killer.kubernetesClient != nil {
+		t.Errorf("Expect cluster to have no Kubernetes client")
+	}
+	if killer.connect() == nil {
+		t.Errorf("Expect connecting again to fail")
+	}
+}
+
+func TestKillPolicyStatuses_NoWhitelistTime(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	// a blacklist over the whole day leaves no time to kill nodes, the policy is rejected instead of hanging the run
+	blacklistHours := "00:00 - 12:00, 12:00 - 00:00"
+	killPolicies := []PreemptibleKillPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "never"},
+			Spec:       PreemptibleKillPolicySpec{NodePool: "batch", PolicySettings: PolicySettings{BlacklistHours: &blacklistHours}},
+		},
+	}
+
+	client := NewMockKubernetesClient(ctrl)
+	client.EXPECT().GetKillPolicies(ctx).Return(killPolicies, nil)
+
+	killer := newTestNodeKiller(client, newFakeClock(time.Now()))
+	killer.loadKillPolicies(ctx)
+
+	if len(killer.policies) != 0 {
+		t.Errorf("Expect the kill policy to be ignored, instead got %v", killer.policies)
+	}
+	status := killer.killPolicyStatuses[Policy{Source: policySourceKillPolicy, Name: "never"}.getKey()]
+	if status == nil || !strings.Contains(status.LastError, "leave no time to kill nodes") {
+		t.Errorf("Expect the kill policy to report it leaves no time to kill nodes, instead got %v", status)
+	}
+}
+
+func TestKillPolicyStatuses_SameNameAsConfigPolicy(t *testing.T) {
+	zerolog.SetGlobalLevel(zerolog.Disabled)
+
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	ctx := context.Background()
+
+	client := NewMockKubernetesClient(ctrl)
+	client.EXPECT().GetKillPolicies(ctx).Return([]PreemptibleKillPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "batch"},
+			Spec:       PreemptibleKillPolicySpec{NodePool: "batch"},
+		},
+	}, nil)
+
+	killer := newTestNodeKiller(client, newFakeClock(time.Now()))
+	configPolicies, err := ClusterConfig{}.getNodePoolPolicies(killer.policy, []PolicyConfig{{Name: "batch", NodePool: "batch-large"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	killer.configPolicies = configPolicies
+	killer.loadKillPolicies(ctx)
+
+	// the config policy named like the kill policy doesn't count in its status
+	killer.recordKillPolicyNode(configPolicies[0], nil)
+
+	killPolicy := killer.policies[0]
+	if killPolicy.getKey() == configPolicies[0].getKey() {
+		t.Errorf("Expect kill policy and config policy to have different keys, instead both have %v", killPolicy.getKey())
+	}
+	if status := killer.killPolicyStatuses[killPolicy.getKey()]; status == nil || status.NodesManaged != 0 {
+		t.Errorf("Expect kill policy status not to count the node of the config policy, instead got %v", status)
+	}
+}
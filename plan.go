@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+)
+
+const (
+	// labelZone is the key of the label Kubernetes uses to store the zone of a node
+	labelZone string = "topology.kubernetes.io/zone"
+)
+
+var (
+	// plan flags
+	planOutput = planCommand.Flag("output", "Output format, `table` or `json`.").
+			Default("table").
+			Short('o').
+			Enum("table", "json")
+	planWithin = planCommand.Flag("within", "Only list nodes scheduled to be killed within this time, 0 lists all nodes. Nodes with a missing or invalid state are always listed.").
+			Default("0s").
+			Duration()
+)
+
+// plannedKill describes when a preemptible node is scheduled to be killed
+type plannedKill struct {
+	Node           string `json:"node"`
+	Pool           string `json:"pool"`
+	Zone           string `json:"zone"`
+	Age            string `json:"age"`
+	ExpiryDatetime string `json:"expiryDatetime"`
+	Status         string `json:"status"`
+
+	expiry time.Time
+}
+
+// runPlanCommand prints the upcoming kills of the cluster the kube config and context point to
+func runPlanCommand(w io.Writer) {
+	ctx := context.Background()
+
+	kubeClientConfig, err := NewKubeClientConfig(*kubeConfigPath, *kubeContext)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error building Kubernetes client config")
+	}
+	kubeClientset, err := kubernetes.NewForConfig(kubeClientConfig)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error creating Kubernetes clientset")
+	}
+
+	dynamicClient, err := dynamic.NewForConfig(kubeClientConfig)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error creating Kubernetes dynamic client")
+	}
+
+	clock := NewRealClock()
+	kubernetesClient, err := NewKubernetesClient(kubeClientset, dynamicClient, clock, NewRandom(clock.Now().UnixNano()))
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error initializing Kubernetes client")
+	}
+
+	filter, err := newDefaultFilterConfig().getNodeFilter()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error parsing filters")
+	}
+
+	nodes, err := kubernetesClient.GetPreemptibleNodes(ctx, filter)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error while getting the list of preemptible nodes")
+	}
+
+	plannedKills := getPlannedKills(clock.Now(), nodes.Items, *planWithin)
+
+	err = printPlannedKills(w, plannedKills, *planOutput)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error printing planned kills")
+	}
+}
+
+// getPlannedKills reads the state of the nodes and returns their planned kills, nodes with a missing or invalid state
+// first followed by the others in order of expiry
+func getPlannedKills(now time.Time, nodes []v1.Node, within time.Duration) (plannedKills []plannedKill) {
+	plannedKills = []plannedKill{}
+
+	for _, node := range nodes {
+		kill := plannedKill{
+			Node: node.ObjectMeta.Name,
+			Pool: node.ObjectMeta.Labels[labelGKENodePool],
+			Zone: getNodeZone(node),
+			Age:  now.Sub(node.ObjectMeta.CreationTimestamp.Time).Round(time.Minute).String(),
+		}
+
+		state := getCurrentNodeState(node)
+		kill.ExpiryDatetime = state.ExpiryDatetime
+
+		if state.ExpiryDatetime == "" {
+			kill.Status = "missing state"
+		} else if expiry, err := time.Parse(time.RFC3339, state.ExpiryDatetime); err != nil {
+			kill.Status = "invalid state"
+		} else {
+			kill.expiry = expiry
+			kill.Status = fmt.Sprintf("in %v", expiry.Sub(now).Round(time.Minute))
+			if !expiry.After(now) {
+				kill.Status = "overdue"
+			}
+
+			if within > 0 && expiry.After(now.Add(within)) {
+				continue
+			}
+		}
+
+		plannedKills = append(plannedKills, kill)
+	}
+
+	sort.SliceStable(plannedKills, func(a, b int) bool {
+		if plannedKills[a].expiry.IsZero() != plannedKills[b].expiry.IsZero() {
+			return plannedKills[a].expiry.IsZero()
+		}
+		return plannedKills[a].expiry.Before(plannedKills[b].expiry)
+	})
+
+	return
+}
+
+// getNodeZone returns the zone of a node from its labels, or from its provider id if the label is missing
+func getNodeZone(node v1.Node) string {
+	if zone, ok := node.ObjectMeta.Labels[labelZone]; ok {
+		return zone
+	}
+
+	s := strings.Split(node.Spec.ProviderID, "/")
+	if len(s) > 3 {
+		return s[3]
+	}
+
+	return ""
+}
+
+// printPlannedKills prints the planned kills as a table or as json
+func printPlannedKills(w io.Writer, plannedKills []plannedKill, output string) (err error) {
+	if output == "json" {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plannedKills)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NODE\tPOOL\tZONE\tAGE\tKILL AT\tSTATUS")
+	for _, kill := range plannedKills {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", kill.Node, kill.Pool, kill.Zone, kill.Age, kill.ExpiryDatetime, kill.Status)
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestGetPlannedKills(t *testing.T) {
+	now := time.Date(2017, 11, 11, 12, 00, 00, 0, time.UTC)
+	creationTimestamp := metav1.Time{Time: now.Add(-13 * time.Hour)}
+
+	nodes := []v1.Node{
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "node-later",
+				CreationTimestamp: creationTimestamp,
+				Labels: map[string]string{
+					"cloud.google.com/gke-nodepool": "pool-1",
+					"topology.kubernetes.io/zone":   "europe-west1-b",
+				},
+				Annotations: map[string]string{
+					"estafette.io/gke-preemptible-killer-state": "2017-11-11T18:00:00Z",
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "node-sooner",
+				CreationTimestamp: creationTimestamp,
+				Annotations: map[string]string{
+					"estafette.io/gke-preemptible-killer-state": "2017-11-11T13:00:00Z",
+				},
+			},
+			Spec: v1.NodeSpec{
+				ProviderID: "gce://project-1/europe-west1-c/node-sooner",
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "node-invalid",
+				CreationTimestamp: creationTimestamp,
+				Annotations: map[string]string{
+					"estafette.io/gke-preemptible-killer-state": "tomorrow",
+				},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:              "node-missing",
+				CreationTimestamp: creationTimestamp,
+			},
+		},
+	}
+
+	plannedKills := getPlannedKills(now, nodes, 0)
+
+	if len(plannedKills) != 4 {
+		t.Fatalf("Expected 4 planned kills, got %d", len(plannedKills))
+	}
+
+	expectedOrder := []string{"node-invalid", "node-missing", "node-sooner", "node-later"}
+	for i, name := range expectedOrder {
+		if plannedKills[i].Node != name {
+			t.Errorf("Expected planned kill %d to be %s, got %s", i, name, plannedKills[i].Node)
+		}
+	}
+
+	if plannedKills[0].Status != "invalid state" || plannedKills[1].Status != "missing state" {
+		t.Errorf("Expected nodes with invalid and missing state to be flagged, got '%s' and '%s'", plannedKills[0].Status, plannedKills[1].Status)
+	}
+
+	if plannedKills[2].Zone != "europe-west1-c" || plannedKills[3].Zone != "europe-west1-b" {
+		t.Errorf("Expected zones europe-west1-c and europe-west1-b, got %s and %s", plannedKills[2].Zone, plannedKills[3].Zone)
+	}
+
+	if plannedKills[3].Pool != "pool-1" || plannedKills[3].Age != "13h0m0s" || plannedKills[3].Status != "in 6h0m0s" {
+		t.Errorf("Expected pool-1 aged 13h0m0s killed in 6h0m0s, got %s aged %s %s", plannedKills[3].Pool, plannedKills[3].Age, plannedKills[3].Status)
+	}
+
+	plannedKills = getPlannedKills(now, nodes, 2*time.Hour)
+
+	if len(plannedKills) != 3 {
+		t.Errorf("Expected 3 planned kills within 2 hours, got %d", len(plannedKills))
+	}
+}
+
+func TestPrintPlannedKills(t *testing.T) {
+	plannedKills := []plannedKill{
+		{
+			Node:           "node-1",
+			Pool:           "pool-1",
+			Zone:           "europe-west1-b",
+			Age:            "13h0m0s",
+			ExpiryDatetime: "2017-11-11T18:00:00Z",
+			Status:         "in 6h0m0s",
+		},
+	}
+
+	var output bytes.Buffer
+	err := printPlannedKills(&output, plannedKills, "table")
+	if err != nil {
+		t.Fatalf("Expected no error printing table, got %v", err)
+	}
+	if !strings.HasPrefix(output.String(), "NODE") || !strings.Contains(output.String(), "node-1  pool-1") {
+		t.Errorf("Expected table with node-1, got:\n%s", output.String())
+	}
+
+	output.Reset()
+	err = printPlannedKills(&output, plannedKills, "json")
+	if err != nil {
+		t.Fatalf("Expected no error printing json, got %v", err)
+	}
+
+	var decoded []plannedKill
+	err = json.Unmarshal(output.Bytes(), &decoded)
+	if err != nil || len(decoded) != 1 || decoded[0].ExpiryDatetime != "2017-11-11T18:00:00Z" {
+		t.Errorf("Expected json with node-1, got:\n%s", output.String())
+	}
+}
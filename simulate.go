@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kingpin"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+var (
+	// simulate flags
+	simulateCreationWindow = simulateCommand.Flag("creation-window", "Time over which the creation of the synthetic nodes is spread randomly, 0 creates them all at once.").
+				Default("0s").
+				Duration()
+	simulateIterations = simulateCommand.Flag("iterations", "Number of times the expiry of all synthetic nodes is calculated.").
+				Default("1000").
+				Int()
+	simulateNodes = simulateCommand.Flag("nodes", "Number of synthetic nodes.").
+			Default("10").
+			Int()
+	simulateStart = simulateCommand.Flag("start", "Creation time of the synthetic nodes in RFC3339 format.").
+			Default("2000-01-01T00:00:00Z").
+			String()
+)
+
+// simulationResult holds the kill times of the synthetic nodes of all iterations of a simulation
+type simulationResult struct {
+	// killsPerHour counts the kills per UTC hour of the day
+	killsPerHour [24]int
+
+	// totalKills is the number of kills over all iterations
+	totalKills int
+
+	// outlivedKills is the number of kills planned after the maximum lifetime of the node
+	outlivedKills int
+
+	// peakConcurrentKills is the largest number of nodes being drained at the same time in any iteration
+	peakConcurrentKills int
+}
+
+// runSimulateCommand runs a simulation with the command line settings and prints its result
+func runSimulateCommand(w io.Writer) {
+	start, err := time.Parse(time.RFC3339, *simulateStart)
+	if err != nil {
+		kingpin.Fatalf("start '%v' should be in RFC3339 format: %v", *simulateStart, err)
+	}
+
+	if *simulateNodes < 1 {
+		kingpin.Fatalf("nodes %d should be at least 1", *simulateNodes)
+	}
+	if *simulateIterations < 1 {
+		kingpin.Fatalf("iterations %d should be at least 1", *simulateIterations)
+	}
+	if *simulateCreationWindow < 0 {
+		kingpin.Fatalf("creation window %v should not be negative", *simulateCreationWindow)
+	}
+
+	seed := *randomSeed
+	if seed == 0 {
+		seed = NewRealClock().Now().UnixNano()
+	}
+
+	policy, err := newDefaultPolicy()
+	if err != nil {
+		kingpin.Fatalf("whitelist and blacklist hours should be valid: %v", err)
+	}
+
+	result := runSimulation(policy, NewRandom(seed), start, *simulateNodes, *simulateCreationWindow, *simulateIterations)
+	printSimulationResult(w, result, *maximumLifetime)
+}
+
+// runSimulation plans the expiry of synthetic nodes the same way getDesiredNodeState does, annotating every node as
+// soon as it's created
+func runSimulation(policy Policy, random Random, start time.Time, nodeCount int, creationWindow time.Duration, iterations int) (result simulationResult) {
+	drainDuration := policy.getMaximumDrainDuration()
+
+	for i := 0; i < iterations; i++ {
+		nodes := make([]v1.Node, nodeCount)
+		for n := range nodes {
+			creationTime := start
+			if creationWindow > 0 {
+				creationTime = creationTime.Add(time.Duration(random.Int63n(int64(creationWindow))))
+			}
+
+			nodes[n] = v1.Node{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              fmt.Sprintf("node-%d", n),
+					CreationTimestamp: metav1.Time{Time: creationTime},
+				},
+			}
+		}
+
+		// annotate nodes in order of creation, like the killer would
+		sort.SliceStable(nodes, func(a, b int) bool {
+			return nodes[a].ObjectMeta.CreationTimestamp.Time.Before(nodes[b].ObjectMeta.CreationTimestamp.Time)
+		})
+
+		expiryDates := []time.Time{}
+		for _, node := range nodes {
+			creationTime := node.ObjectMeta.CreationTimestamp.Time
+			expiryDate := planExpiryDate(creationTime, policy, random, node, expiryDates)
+
+			result.killsPerHour[expiryDate.UTC().Hour()]++
+			result.totalKills++
+			if expiryDate.After(creationTime.Add(policy.MaximumLifetime)) {
+				result.outlivedKills++
+			}
+
+			expiryDates = append(expiryDates, expiryDate)
+		}
+
+		concurrentKills := getPeakConcurrentKills(expiryDates, drainDuration)
+		if concurrentKills > result.peakConcurrentKills {
+			result.peakConcurrentKills = concurrentKills
+		}
+	}
+
+	return
+}
+
+// getPeakConcurrentKills returns the largest number of nodes being drained at the same time, assuming every drain
+// takes the full drain timeout
+func getPeakConcurrentKills(expiryDates []time.Time, drainTimeout time.Duration) (peak int) {
+	sortedExpiryDates := make([]time.Time, len(expiryDates))
+	copy(sortedExpiryDates, expiryDates)
+	sort.Slice(sortedExpiryDates, func(a, b int) bool {
+		return sortedExpiryDates[a].Before(sortedExpiryDates[b])
+	})
+
+	// slide a window the length of the drain timeout over the sorted kills
+	first := 0
+	for last := range sortedExpiryDates {
+		for !sortedExpiryDates[last].Before(sortedExpiryDates[first].Add(drainTimeout)) && first < last {
+			first++
+		}
+		if last-first+1 > peak {
+			peak = last - first + 1
+		}
+	}
+
+	return
+}
+
+// printSimulationResult prints a histogram of the kills per hour and the kill statistics
+func printSimulationResult(w io.Writer, result simulationResult, maximumLifetime time.Duration) {
+	if result.totalKills == 0 {
+		fmt.Fprintln(w, "No kills simulated")
+		return
+	}
+
+	maxKills := 0
+	for _, kills := range result.killsPerHour {
+		if kills > maxKills {
+			maxKills = kills
+		}
+	}
+
+	fmt.Fprintln(w, "Kills per hour (UTC):")
+	for hour, kills := range result.killsPerHour {
+		bar := 0
+		if maxKills > 0 {
+			bar = kills * 50 / maxKills
+		}
+		fmt.Fprintf(w, "%02d:00 %-50s %d (%.1f%%)\n", hour, strings.Repeat("#", bar), kills, 100*float64(kills)/float64(result.totalKills))
+	}
+
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "Nodes outliving their maximum lifetime of %v: %.1f%%\n", maximumLifetime, 100*float64(result.outlivedKills)/float64(result.totalKills))
+	fmt.Fprintf(w, "Peak concurrent kills: %d\n", result.peakConcurrentKills)
+}
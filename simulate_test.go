@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSimulation(t *testing.T) {
+	start := time.Date(2017, 11, 11, 12, 00, 00, 0, time.UTC)
+
+	policy := newTestPolicy()
+	policy.Whitelist, _ = NewWhitelistInstance("09:00 - 12:00", "")
+
+	result := runSimulation(policy, NewRandom(0), start, 10, time.Hour, 100)
+
+	if result.totalKills != 1000 {
+		t.Errorf("Expected 1000 kills, got %d", result.totalKills)
+	}
+
+	for hour, kills := range result.killsPerHour {
+		if (hour < 9 || hour >= 12) && kills > 0 {
+			t.Errorf("Expected no kills outside of the whitelist, got %d at %02d:00", kills, hour)
+		}
+	}
+
+	if result.peakConcurrentKills < 1 || result.peakConcurrentKills > 10 {
+		t.Errorf("Expected peak concurrent kills between 1 and 10, got %d", result.peakConcurrentKills)
+	}
+}
+
+func TestGetPeakConcurrentKills(t *testing.T) {
+	start := time.Date(2017, 11, 11, 12, 00, 00, 0, time.UTC)
+	expiryDates := []time.Time{
+		start.Add(20 * time.Minute),
+		start,
+		start.Add(2 * time.Minute),
+		start.Add(4 * time.Minute),
+		start.Add(22 * time.Minute),
+	}
+
+	peak := getPeakConcurrentKills(expiryDates, 5*time.Minute)
+	if peak != 3 {
+		t.Errorf("Expected peak of 3 concurrent kills, got %d", peak)
+	}
+}
+
+func TestPrintSimulationResult(t *testing.T) {
+	result := simulationResult{totalKills: 4, outlivedKills: 1, peakConcurrentKills: 2}
+	result.killsPerHour[10] = 4
+
+	var output bytes.Buffer
+	printSimulationResult(&output, result, 24*time.Hour)
+
+	if !strings.Contains(output.String(), "10:00 "+strings.Repeat("#", 50)+" 4 (100.0%)") {
+		t.Errorf("Expected histogram line for 10:00, got:\n%s", output.String())
+	}
+	if !strings.Contains(output.String(), "25.0%") {
+		t.Errorf("Expected 25%% of nodes outliving their maximum lifetime, got:\n%s", output.String())
+	}
+	if !strings.Contains(output.String(), "Peak concurrent kills: 2") {
+		t.Errorf("Expected peak concurrent kills of 2, got:\n%s", output.String())
+	}
+}
@@ -55,24 +55,47 @@ type WhitelistInstance struct {
 	whitelistSecondCount int
 }
 
+// NewWhitelistInstance returns a WhitelistInstance with the given whitelist and blacklist hours parsed
+func NewWhitelistInstance(whitelist string, blacklist string) (w WhitelistInstance, err error) {
+	w.whitelist = whitelist
+	w.blacklist = blacklist
+	err = w.parseArguments()
+
+	return
+}
+
 // initializeWhitelistHours initializes data structures by taking command line arguments into account.
 func (w *WhitelistInstance) initialize() {
 	w.whitelistHours = timespanset.Empty()
 	w.whitelistSecondCount = 0
 }
 
-func (w *WhitelistInstance) parseArguments() {
+func (w *WhitelistInstance) parseArguments() (err error) {
 	w.initialize()
 	if len(w.whitelist) == 0 {
 		// If there's no whitelist, than the maximum range has to be allowed so that any blacklist
 		// might be subtracted from it.
-		w.processHours("00:00 - 12:00, 12:00 - 00:00", "+")
+		err = w.processHours("00:00 - 12:00, 12:00 - 00:00", "+")
 	} else {
-		w.processHours(w.whitelist, "+")
+		err = w.processHours(w.whitelist, "+")
+	}
+	if err != nil {
+		return fmt.Errorf("whitelist hours: %v", err)
+	}
+
+	err = w.processHours(w.blacklist, "-")
+	if err != nil {
+		return fmt.Errorf("blacklist hours: %v", err)
 	}
 
-	w.processHours(w.blacklist, "-")
 	w.whitelistHours.IntervalsBetween(whitelistStart, whitelistEnd, w.updateWhitelistSecondCount)
+
+	// Without any whitelist time left no expiry can ever be found.
+	if w.whitelistSecondCount == 0 {
+		return fmt.Errorf("whitelist hours '%v' and blacklist hours '%v' leave no time to kill nodes", w.whitelist, w.blacklist)
+	}
+
+	return
 }
 
 // getExpiryDate calculates the expiry date of a node.
@@ -104,9 +127,8 @@ func (w *WhitelistInstance) getExpiryDate(t time.Time, timeToBeAdded time.Durati
 			if timeToBeAdded <= intervalDuration {
 				// This is it, project it back to real time.
 				expiryDatetime = truncatedCreationTime.Add(start.Add(timeToBeAdded).Sub(whitelistStart))
-				// But if expiryDatetime is before creation...
-				fmt.Println("before creation? " + expiryDatetime.String() + " " + t.String())
-				if expiryDatetime.Before(t) {
+				// But if expiryDatetime is not after creation, which it only equals after wrapping around a whole day...
+				if !expiryDatetime.After(t) {
 					// Simply add 24h.
 					expiryDatetime = expiryDatetime.Add(24 * time.Hour)
 				}
@@ -138,10 +160,10 @@ func (w *WhitelistInstance) mergeTimespans(start time.Time, end time.Time, direc
 }
 
 // processHours parses time stamps and passes them to mergeTimespans(), direction can be "+" or "-".
-func (w *WhitelistInstance) processHours(input string, direction string) {
+func (w *WhitelistInstance) processHours(input string, direction string) error {
 	// Time not specified, continue with no restrictions.
 	if len(input) == 0 {
-		return
+		return nil
 	}
 
 	// Split in intervals.
@@ -152,19 +174,19 @@ func (w *WhitelistInstance) processHours(input string, direction string) {
 
 		// Check format.
 		if len(times) != 2 {
-			panic(fmt.Sprintf("processHours(): interval '%v' should be of the form `09:00 - 11:00[, 21:00 - 23:00[, ...]]`", timeInterval))
+			return fmt.Errorf("interval '%v' should be of the form `09:00 - 11:00[, 21:00 - 23:00[, ...]]`", timeInterval)
 		}
 
 		// Start time
 		start, err := time.Parse(time.RFC3339, whitelistStartPrefix+times[0]+whitelistTimePostfix)
 		if err != nil {
-			panic(fmt.Sprintf("processHours(): %v cannot be parsed: %v", times[0], err))
+			return fmt.Errorf("%v cannot be parsed: %v", times[0], err)
 		}
 
 		// End time
 		end, err := time.Parse(time.RFC3339, whitelistStartPrefix+times[1]+whitelistTimePostfix)
 		if err != nil {
-			panic(fmt.Sprintf("processHours(): %v cannot be parsed: %v", times[1], err))
+			return fmt.Errorf("%v cannot be parsed: %v", times[1], err)
 		}
 
 		// If end is before start it means it contains midnight, so split in two.
@@ -176,6 +198,8 @@ func (w *WhitelistInstance) processHours(input string, direction string) {
 		// Merge timespans.
 		w.mergeTimespans(start, end, direction)
 	}
+
+	return nil
 }
 
 // updateWhitelistSecondCount adds the difference between two times to an accumulator.
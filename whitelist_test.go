@@ -92,10 +92,33 @@ func TestProcessHours(t *testing.T) {
 	// Check that argument parsing works.
 	i.whitelist = "00:00 - 04:00, 08:00 - 12:00, 16:00 - 20:00"
 	i.blacklist = "01:00 - 02:00, 06:00 - 14:00, 15:00 - 17:00"
-	i.parseArguments()
+	err := i.parseArguments()
+	if err != nil {
+		t.Fatalf("Expected arguments to parse, got %v", err)
+	}
 	if i.whitelistSecondCount != 21600 {
 		t.Errorf("Expected 21600 seconds, got '%v'", i.whitelistSecondCount)
 	}
+
+	// Check that invalid intervals are reported.
+	for _, input := range []string{"09:00", "09:00 - 25:00", "nine - ten"} {
+		_, err = NewWhitelistInstance(input, "")
+		if err == nil {
+			t.Errorf("Expected error for whitelist hours '%v'", input)
+		}
+		_, err = NewWhitelistInstance("", input)
+		if err == nil {
+			t.Errorf("Expected error for blacklist hours '%v'", input)
+		}
+	}
+
+	// Check that hours leaving no whitelist time are reported.
+	for _, hours := range [][2]string{{"", "00:00 - 12:00, 12:00 - 00:00"}, {"09:00 - 10:00", "08:00 - 11:00"}} {
+		_, err = NewWhitelistInstance(hours[0], hours[1])
+		if err == nil {
+			t.Errorf("Expected error for whitelist hours '%v' and blacklist hours '%v'", hours[0], hours[1])
+		}
+	}
 }
 
 func TestUpdateWhitelistSecondCount(t *testing.T) {
@@ -131,3 +154,22 @@ func TestUpdateWhitelistSecondCount(t *testing.T) {
 	}()
 	i.updateWhitelistSecondCount(b, a)
 }
+
+func TestGetExpiryDate_FullDay(t *testing.T) {
+	w, err := NewWhitelistInstance("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creation := time.Date(2017, 11, 11, 12, 00, 00, 0, time.UTC)
+
+	// a whole day of whitelist time from noon wraps around to noon, which is the next day and not the creation
+	expiry := w.getExpiryDate(creation, 24*time.Hour)
+	if !expiry.Equal(creation.Add(24 * time.Hour)) {
+		t.Errorf("Expected expiry at %v, got %v", creation.Add(24*time.Hour), expiry)
+	}
+
+	expiry = w.getExpiryDate(creation, 18*time.Hour)
+	if !expiry.Equal(creation.Add(18 * time.Hour)) {
+		t.Errorf("Expected expiry at %v, got %v", creation.Add(18*time.Hour), expiry)
+	}
+}